@@ -0,0 +1,46 @@
+package data
+
+import "time"
+
+// Scopes de las API keys. Una API key solo sirve en las rutas protegidas con
+// middleware.Scoped para alguno de sus scopes.
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopePostsRead    = "posts:read"
+	ScopePostsWrite   = "posts:write"
+	ScopeFriendsRead  = "friends:read"
+	ScopeFriendsWrite = "friends:write"
+)
+
+var APIKeyScopes = []string{ScopeProfileRead, ScopePostsRead, ScopePostsWrite, ScopeFriendsRead, ScopeFriendsWrite}
+
+func IsValidScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey es una clave para integraciones entre servidores. Solo se guarda el
+// hash de la clave; Prefix es su comienzo, para que el usuario la reconozca.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Username   string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package data
+
+import "time"
+
+// Tipos de eventos de auditoria de inicio de sesion.
+const (
+	AuditLoginFailed   = "login_failed"
+	AuditLoginBlocked  = "login_blocked"
+	AuditAccountLocked = "account_locked"
+)
+
+// AuditEvent registra un evento de seguridad de un usuario, como un inicio de
+// sesion fallido.
+type AuditEvent struct {
+	Type      string    `json:"type"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,12 @@
+package data
+
+import "time"
+
+// RestrictedUser es un usuario bloqueado, (:User)-[:BLOCKED]->(:User), o
+// silenciado, (:User)-[:MUTED]->(:User), y desde cuando. Un bloqueo oculta a
+// cada usuario el contenido del otro; silenciar solo saca sus posts del feed
+// de quien silencia.
+type RestrictedUser struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,14 @@
+package data
+
+import "time"
+
+type Comment struct {
+	ID         string    `json:"id"`
+	PostID     string    `json:"postID"`
+	ParentID   string    `json:"parentID,omitempty"`
+	Author     string    `json:"author"`
+	Content    string    `json:"content"`
+	ReplyCount int       `json:"replyCount"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,20 @@
+package data
+
+import "time"
+
+// Estados de la relacion (:User)-[:FRIEND {status}]->(:User). La relacion va
+// siempre de quien envia la solicitud a quien la recibe.
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+type FriendRequest struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
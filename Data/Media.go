@@ -0,0 +1,24 @@
+package data
+
+// Media es un archivo adjunto a un post, guardado como
+// (:Post)-[:HAS_MEDIA {position}]->(:Media).
+type Media struct {
+	ID          string      `json:"id"`
+	Key         string      `json:"-"`
+	URL         string      `json:"url"`
+	ContentType string      `json:"contentType"`
+	Size        int64       `json:"size"`
+	Width       int         `json:"width,omitempty"`
+	Height      int         `json:"height,omitempty"`
+	Renditions  []Rendition `json:"renditions,omitempty"`
+}
+
+// Rendition es una version reducida de una imagen (thumbnail, medium),
+// guardada como (:Media)-[:HAS_RENDITION]->(:Rendition).
+type Rendition struct {
+	Name   string `json:"name"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
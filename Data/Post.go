@@ -1,9 +1,32 @@
 package data
 
+import "time"
+
 type Post struct {
-	ID       string   `json:"id"`
-	Content  string   `json:"content"`
-	Likes    int      `json:"likes"`
-	Comments []string `json:"comments"`
-	ImageURL string   `json:"imageURL"`
+	ID           string         `json:"id"`
+	Author       string         `json:"author"`
+	Content      string         `json:"content"`
+	Likes        int            `json:"likes"`
+	LikedByMe    bool           `json:"likedByMe"`
+	Reactions    map[string]int `json:"reactions"`
+	MyReaction   string         `json:"myReaction,omitempty"`
+	CommentCount int            `json:"commentCount"`
+	Media        []Media        `json:"media"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+	Visibility   string         `json:"visibility"`
+	// VisibleTo son los usuarios que pueden ver un post custom. Solo se
+	// devuelve a su autor.
+	VisibleTo []string `json:"visibleTo,omitempty"`
+}
+
+// PostRevision es una version anterior del contenido de un post, guardada
+// como (:Post)-[:PREVIOUS_VERSION]->(:PostRevision) cada vez que se edita.
+type PostRevision struct {
+	ID         string    `json:"id"`
+	PostID     string    `json:"postID"`
+	Version    int       `json:"version"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ReplacedAt time.Time `json:"replacedAt"`
 }
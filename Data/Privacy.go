@@ -0,0 +1,50 @@
+package data
+
+// Visibilidad de un post, guardada en la propiedad visibility del nodo Post.
+// Los posts anteriores no la tienen y son publicos.
+const (
+	VisibilityPublic           = "public"
+	VisibilityFriends          = "friends"
+	VisibilityFriendsOfFriends = "friends_of_friends"
+	VisibilityOnlyMe           = "only_me"
+	// VisibilityCustom solo deja ver el post a los usuarios enlazados con
+	// (:Post)-[:VISIBLE_TO]->(:User).
+	VisibilityCustom = "custom"
+)
+
+var Visibilities = []string{VisibilityPublic, VisibilityFriends, VisibilityFriendsOfFriends, VisibilityOnlyMe, VisibilityCustom}
+
+func IsValidVisibility(visibility string) bool {
+	for _, v := range Visibilities {
+		if v == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// Quien puede enviar solicitudes de amistad a un usuario.
+const (
+	FriendRequestsEveryone         = "everyone"
+	FriendRequestsFriendsOfFriends = "friends_of_friends"
+	FriendRequestsNobody           = "nobody"
+)
+
+var FriendRequestPolicies = []string{FriendRequestsEveryone, FriendRequestsFriendsOfFriends, FriendRequestsNobody}
+
+func IsValidFriendRequestPolicy(policy string) bool {
+	for _, p := range FriendRequestPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivacySettings es la configuracion de privacidad de la cuenta. Con
+// PrivateProfile solo los amigos ven el perfil completo y los posts, aunque
+// sean publicos.
+type PrivacySettings struct {
+	PrivateProfile bool   `json:"privateProfile"`
+	FriendRequests string `json:"friendRequests"`
+}
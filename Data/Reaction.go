@@ -0,0 +1,31 @@
+package data
+
+import "time"
+
+// Tipos de reaccion guardados en (:User)-[:REACTED {type}]->(:Post). Un
+// usuario tiene como mucho una reaccion por post.
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+	ReactionAngry = "angry"
+)
+
+var ReactionTypes = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad, ReactionAngry}
+
+func IsValidReaction(reactionType string) bool {
+	for _, t := range ReactionTypes {
+		if t == reactionType {
+			return true
+		}
+	}
+	return false
+}
+
+type Reaction struct {
+	Username  string    `json:"username"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+}
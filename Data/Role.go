@@ -0,0 +1,30 @@
+package data
+
+// Roles de los usuarios, guardados en la propiedad roles del nodo User. Un
+// admin tiene tambien los permisos de moderator.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole indica si roles da los permisos de role.
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,84 @@
 package data
 
+import "time"
+
+// User es el usuario tal como se guarda en Neo4j, incluido el hash de la
+// contrasena. Es solo para uso interno: las respuestas HTTP deben usar
+// Profile, que es lo que devuelve PublicProfile.
 type User struct {
-	Username string
-	Email    string
-	Password string
+	ID       string `json:"-"`
+	Username string `json:"-"`
+	Email    string `json:"-"`
+	// EmailVerified indica si el usuario confirmo que Email es suyo.
+	EmailVerified bool      `json:"-"`
+	PasswordHash  string    `json:"-"`
+	Roles         []string  `json:"-"`
+	DisplayName   string    `json:"-"`
+	Bio           string    `json:"-"`
+	AvatarURL     string    `json:"-"`
+	Location      string    `json:"-"`
+	Website       string    `json:"-"`
+	JoinedAt      time.Time `json:"-"`
+
+	// PendingEmail es el nuevo email mientras no se confirma el cambio.
+	PendingEmail string `json:"-"`
+	// DeleteAfter es cuando se borrara la cuenta; cero si no esta
+	// programado su borrado.
+	DeleteAfter time.Time `json:"-"`
+
+	// TOTPEnabled indica si el usuario tiene activada la verificacion en dos
+	// pasos con TOTPSecret. TOTPPendingSecret es el secreto generado que
+	// todavia no ha confirmado.
+	TOTPEnabled       bool   `json:"-"`
+	TOTPSecret        string `json:"-"`
+	TOTPPendingSecret string `json:"-"`
+	// RecoveryCodes son los hashes bcrypt de los codigos de recuperacion
+	// que quedan por usar.
+	RecoveryCodes []string `json:"-"`
+
+	Privacy PrivacySettings `json:"-"`
+}
+
+func (u *User) PublicProfile() *Profile {
+	return &Profile{
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+		Location:    u.Location,
+		Website:     u.Website,
+		JoinedAt:    u.JoinedAt,
+		Private:     u.Privacy.PrivateProfile,
+	}
+}
+
+// Profile es la informacion publica de un usuario.
+type Profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatarURL"`
+	Location    string    `json:"location"`
+	Website     string    `json:"website"`
+	JoinedAt    time.Time `json:"joinedAt"`
+	Private     bool      `json:"private"`
+}
+
+// Limited devuelve lo que ve del perfil privado quien no es su amigo.
+func (p *Profile) Limited() *Profile {
+	return &Profile{
+		Username:    p.Username,
+		DisplayName: p.DisplayName,
+		AvatarURL:   p.AvatarURL,
+		Private:     p.Private,
+	}
+}
+
+// ProfileUpdate contiene los campos del perfil que se quieren cambiar; los
+// campos nil no se modifican.
+type ProfileUpdate struct {
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	Location    *string `json:"location"`
+	Website     *string `json:"website"`
 }
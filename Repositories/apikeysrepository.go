@@ -0,0 +1,175 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// APIKeyRepository guarda las API keys de los usuarios como
+// (:User)-[:HAS_API_KEY]->(:APIKey). De cada clave solo se guarda su hash.
+type APIKeyRepository interface {
+	CreateAPIKey(username, hash string, key data.APIKey) error
+	GetAPIKeys(username string) ([]data.APIKey, error)
+	RevokeAPIKey(username, keyID string) error
+	AuthenticateAPIKey(hash string, at time.Time) (*data.APIKey, error)
+}
+
+type apiKeyRepository struct {
+	driver neo4j.Driver
+}
+
+func NewAPIKeyRepository(driver neo4j.Driver) APIKeyRepository {
+	return &apiKeyRepository{driver}
+}
+
+const apiKeyFields = `k.id AS id, u.id AS userID, u.username AS username, k.name AS name, k.prefix AS prefix,
+	k.scopes AS scopes, k.createdAt AS createdAt, k.lastUsedAt AS lastUsedAt`
+
+func apiKeyFromRecord(record *neo4j.Record) data.APIKey {
+	key := data.APIKey{
+		ID:        recordString(record, "id"),
+		UserID:    recordString(record, "userID"),
+		Username:  recordString(record, "username"),
+		Name:      recordString(record, "name"),
+		Prefix:    recordString(record, "prefix"),
+		Scopes:    recordStrings(record, "scopes"),
+		CreatedAt: recordTime(record, "createdAt"),
+	}
+	if lastUsedAt := recordTime(record, "lastUsedAt"); !lastUsedAt.IsZero() {
+		key.LastUsedAt = &lastUsedAt
+	}
+	return key
+}
+
+func (r *apiKeyRepository) CreateAPIKey(username, hash string, key data.APIKey) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})
+			 CREATE (u)-[:HAS_API_KEY]->(k:APIKey {
+			     id: $id, hash: $hash, name: $name, prefix: $prefix, scopes: $scopes, createdAt: $createdAt
+			 })
+			 RETURN count(k) AS created`,
+			map[string]interface{}{
+				"username":  username,
+				"id":        key.ID,
+				"hash":      hash,
+				"name":      key.Name,
+				"prefix":    key.Prefix,
+				"scopes":    key.Scopes,
+				"createdAt": key.CreatedAt.UnixMilli(),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "created", ErrUserNotFound)
+	})
+	return err
+}
+
+// GetAPIKeys devuelve las API keys del usuario, de la mas nueva a la mas
+// antigua.
+func (r *apiKeyRepository) GetAPIKeys(username string) ([]data.APIKey, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})-[:HAS_API_KEY]->(k:APIKey)
+			 RETURN `+apiKeyFields+`
+			 ORDER BY k.createdAt DESC`,
+			map[string]interface{}{"username": username},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := []data.APIKey{}
+		for result.Next() {
+			keys = append(keys, apiKeyFromRecord(result.Record()))
+		}
+		return keys, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]data.APIKey), nil
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(username, keyID string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (:User {username: $username})-[:HAS_API_KEY]->(k:APIKey {id: $id})
+			 DETACH DELETE k
+			 RETURN count(k) AS deleted`,
+			map[string]interface{}{"username": username, "id": keyID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "deleted", ErrAPIKeyNotFound)
+	})
+	return err
+}
+
+// apiKeyLastUsedPrecision es cada cuanto se actualiza lastUsedAt como
+// mucho, para no escribir en la base de datos en cada peticion.
+const apiKeyLastUsedPrecision = time.Minute
+
+// AuthenticateAPIKey busca la API key con ese hash y apunta que se ha usado
+// en at, si la ultima vez apuntada fue hace mas de apiKeyLastUsedPrecision.
+// Devuelve nil si no existe o si su usuario tiene el borrado programado.
+func (r *apiKeyRepository) AuthenticateAPIKey(hash string, at time.Time) (*data.APIKey, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User)-[:HAS_API_KEY]->(k:APIKey {hash: $hash})
+			 WHERE u.deleteAfter IS NULL
+			 RETURN `+apiKeyFields,
+			map[string]interface{}{"hash": hash},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			return (*data.APIKey)(nil), result.Err()
+		}
+		key := apiKeyFromRecord(result.Record())
+		return &key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	key := result.(*data.APIKey)
+	if key == nil || (key.LastUsedAt != nil && at.Sub(*key.LastUsedAt) < apiKeyLastUsedPrecision) {
+		return key, nil
+	}
+
+	_, err = session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`MATCH (k:APIKey {id: $id}) WHERE coalesce(k.lastUsedAt, 0) < $before
+			 SET k.lastUsedAt = $at`,
+			map[string]interface{}{
+				"id":     key.ID,
+				"at":     at.UnixMilli(),
+				"before": at.Add(-apiKeyLastUsedPrecision).UnixMilli(),
+			},
+		)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &at
+	return key, nil
+}
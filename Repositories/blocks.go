@@ -0,0 +1,161 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// BlockUser bloquea a blocked y borra cualquier amistad o solicitud entre los
+// dos usuarios. Bloquear dos veces no es un error.
+func (graph *friendsRepository) BlockUser(username, blocked string) error {
+	if username == blocked {
+		return ErrSelfRestriction
+	}
+
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})
+			 MATCH (u2:User {username: $other})
+			 OPTIONAL MATCH (u)-[f:FRIEND]-(u2)
+			 WITH u, u2, collect(f) AS friendships
+			 FOREACH (f IN friendships | DELETE f)
+			 MERGE (u)-[r:BLOCKED]->(u2)
+			 ON CREATE SET r.createdAt = timestamp()
+			 RETURN count(r) AS created`,
+			map[string]interface{}{"username": username, "other": blocked})
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "created", ErrUserNotFound)
+	})
+	return err
+}
+
+func (graph *friendsRepository) UnblockUser(username, blocked string) error {
+	return graph.removeRestriction("BLOCKED", username, blocked, ErrNotBlocked)
+}
+
+// GetBlockedUsers devuelve los usuarios bloqueados por username, del bloqueo
+// mas reciente al mas antiguo.
+func (graph *friendsRepository) GetBlockedUsers(username string) ([]data.RestrictedUser, error) {
+	return graph.getRestrictedUsers("BLOCKED", username)
+}
+
+// MuteUser silencia a muted: sus posts dejan de salir en el feed de
+// username. Silenciar dos veces no es un error.
+func (graph *friendsRepository) MuteUser(username, muted string) error {
+	if username == muted {
+		return ErrSelfRestriction
+	}
+
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})
+			 MATCH (u2:User {username: $other})
+			 MERGE (u)-[r:MUTED]->(u2)
+			 ON CREATE SET r.createdAt = timestamp()
+			 RETURN count(r) AS created`,
+			map[string]interface{}{"username": username, "other": muted})
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "created", ErrUserNotFound)
+	})
+	return err
+}
+
+func (graph *friendsRepository) UnmuteUser(username, muted string) error {
+	return graph.removeRestriction("MUTED", username, muted, ErrNotMuted)
+}
+
+// GetMutedUsers devuelve los usuarios silenciados por username, del mas
+// reciente al mas antiguo.
+func (graph *friendsRepository) GetMutedUsers(username string) ([]data.RestrictedUser, error) {
+	return graph.getRestrictedUsers("MUTED", username)
+}
+
+// removeRestriction borra la relacion relType (BLOCKED o MUTED) de username
+// a other, o devuelve notFound si no existe.
+func (graph *friendsRepository) removeRestriction(relType, username, other string, notFound error) error {
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (:User {username: $username})-[r:`+relType+`]->(:User {username: $other})
+			 DELETE r
+			 RETURN count(r) AS deleted`,
+			map[string]interface{}{"username": username, "other": other})
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "deleted", notFound)
+	})
+	return err
+}
+
+func (graph *friendsRepository) getRestrictedUsers(relType, username string) ([]data.RestrictedUser, error) {
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (:User {username: $username})-[r:`+relType+`]->(u:User)
+			 RETURN u.username AS username, r.createdAt AS createdAt
+			 ORDER BY r.createdAt DESC`,
+			map[string]interface{}{"username": username})
+		if err != nil {
+			return nil, err
+		}
+
+		users := []data.RestrictedUser{}
+		for result.Next() {
+			record := result.Record()
+			users = append(users, data.RestrictedUser{
+				Username:  recordString(record, "username"),
+				CreatedAt: recordTime(record, "createdAt"),
+			})
+		}
+		if err = result.Err(); err != nil {
+			return nil, err
+		}
+		return users, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]data.RestrictedUser), nil
+}
+
+// checkNotBlocked comprueba que ninguno de los dos usuarios ha bloqueado al
+// otro. Para quien esta bloqueado el otro usuario no existe.
+func checkNotBlocked(transaction neo4j.Transaction, username, other string) error {
+	result, err := transaction.Run(
+		`MATCH (u:User {username: $username})
+		 MATCH (u2:User {username: $other})
+		 RETURN size([(u)-[:BLOCKED]->(u2) | 1]) > 0 AS blocking,
+		        size([(u2)-[:BLOCKED]->(u) | 1]) > 0 AS blockedBy`,
+		map[string]interface{}{"username": username, "other": other})
+	if err != nil {
+		return err
+	}
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return err
+		}
+		return ErrUserNotFound
+	}
+
+	record := result.Record()
+	switch {
+	case recordBool(record, "blockedBy"):
+		return ErrUserNotFound
+	case recordBool(record, "blocking"):
+		return ErrUserBlocked
+	}
+	return nil
+}
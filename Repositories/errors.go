@@ -0,0 +1,34 @@
+package Repositories
+
+import "errors"
+
+var (
+	ErrUserNotFound     = errors.New("usuario no encontrado")
+	ErrUsernameTaken    = errors.New("el username ya está en uso")
+	ErrIdentityLinked   = errors.New("la identidad ya esta enlazada a otro usuario")
+	ErrLastAdmin        = errors.New("no se puede quitar el rol admin al ultimo admin")
+	ErrNoPendingEmail   = errors.New("no hay un cambio de email pendiente")
+	ErrNoPendingDelete  = errors.New("la cuenta no tiene un borrado pendiente")
+	ErrEmailChanged     = errors.New("el email del usuario ha cambiado")
+	ErrNoPendingTOTP    = errors.New("no hay una verificacion en dos pasos pendiente de confirmar")
+	ErrTOTPCodeUsed     = errors.New("el codigo ya se ha usado")
+	ErrRecoveryCodeUsed = errors.New("el codigo de recuperacion ya se ha usado")
+	ErrAPIKeyNotFound   = errors.New("API key no encontrada")
+	ErrPostNotFound     = errors.New("post no encontrado")
+	ErrNotPostAuthor    = errors.New("el post pertenece a otro usuario")
+	ErrEmptyPost        = errors.New("el post debe tener contenido o algun adjunto")
+	ErrCommentNotFound  = errors.New("comentario no encontrado")
+	ErrNotCommentAuthor = errors.New("el comentario pertenece a otro usuario")
+	ErrInvalidCursor    = errors.New("cursor invalido")
+
+	ErrFriendRequestNotFound    = errors.New("solicitud de amistad no encontrada")
+	ErrFriendRequestExists      = errors.New("ya existe una solicitud de amistad pendiente")
+	ErrAlreadyFriends           = errors.New("los usuarios ya son amigos")
+	ErrNotFriends               = errors.New("los usuarios no son amigos")
+	ErrSelfFriendRequest        = errors.New("no puedes enviarte una solicitud de amistad")
+	ErrFriendRequestsNotAllowed = errors.New("el usuario no acepta tu solicitud de amistad")
+	ErrUserBlocked              = errors.New("has bloqueado a este usuario")
+	ErrNotBlocked               = errors.New("el usuario no esta bloqueado")
+	ErrNotMuted                 = errors.New("el usuario no esta silenciado")
+	ErrSelfRestriction          = errors.New("no puedes bloquearte ni silenciarte a ti mismo")
+)
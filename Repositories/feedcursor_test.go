@@ -0,0 +1,101 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func TestFeedCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		post      data.Post
+		wantMilli int64
+	}{
+		{"con fecha", data.Post{ID: "p1", CreatedAt: time.UnixMilli(1700000000123)}, 1700000000123},
+		// Los posts sin createdAt se ordenan con coalesce(p.createdAt, 0);
+		// el cursor tiene que valer lo mismo para que la pagina siguiente no
+		// salga vacia.
+		{"sin fecha", data.Post{ID: "legacy"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := decodeFeedCursor(encodeFeedCursor(tt.post))
+			if err != nil {
+				t.Fatalf("decodeFeedCursor: %v", err)
+			}
+			if cursor.CreatedAt != tt.wantMilli || cursor.ID != tt.post.ID {
+				t.Errorf("cursor = %+v, se esperaba {%d %s}", *cursor, tt.wantMilli, tt.post.ID)
+			}
+		})
+	}
+}
+
+func TestDecodeFeedCursorInvalid(t *testing.T) {
+	for _, value := range []string{"no es base64!", "bm8gZXMganNvbg", "e30"} {
+		if _, err := decodeFeedCursor(value); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeFeedCursor(%q) = %v, se esperaba ErrInvalidCursor", value, err)
+		}
+	}
+}
+
+func TestGetFeedPagesThroughPostsWithoutDate(t *testing.T) {
+	driver, prefix := newTestDriver(t)
+	users := NewUserRepository(driver)
+	friends := NewFriendsRepository(driver)
+	posts := NewPostsRepository(driver)
+
+	author, reader := prefix+"author", prefix+"reader"
+	for _, username := range []string{author, reader} {
+		if err := users.CreateUser(username, "hash", username+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	if err := friends.AddFriend(author, reader); err != nil {
+		t.Fatalf("AddFriend: %v", err)
+	}
+	if err := friends.AcceptFriendRequest(author, reader); err != nil {
+		t.Fatalf("AcceptFriendRequest: %v", err)
+	}
+
+	// Dos posts con fecha y dos anteriores a que se guardara createdAt; el
+	// feed los devuelve en este orden.
+	want := []string{prefix + "-new", prefix + "-old", prefix + "-legacy-b", prefix + "-legacy-a"}
+	now := time.Now()
+	for i, id := range want {
+		post := data.Post{ID: id, Content: id, Visibility: data.VisibilityPublic, CreatedAt: now.Add(-time.Duration(i) * time.Minute)}
+		if err := posts.CreatePost(author, post); err != nil {
+			t.Fatalf("CreatePost: %v", err)
+		}
+	}
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	if _, err := session.Run(
+		`MATCH (p:Post) WHERE p.id IN $ids REMOVE p.createdAt, p.updatedAt`,
+		map[string]interface{}{"ids": want[2:]}); err != nil {
+		t.Fatalf("Error quitando createdAt: %v", err)
+	}
+
+	var got []string
+	cursor := ""
+	for page := 0; page <= len(want); page++ {
+		feed, next, err := posts.GetFeed(reader, cursor, 1)
+		if err != nil {
+			t.Fatalf("GetFeed: %v", err)
+		}
+		for _, post := range feed {
+			got = append(got, post.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("feed = %v, se esperaba %v", got, want)
+	}
+}
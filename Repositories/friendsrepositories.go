@@ -1,12 +1,29 @@
 package Repositories
 
-import "github.com/neo4j/neo4j-go-driver/v4/neo4j"
+import (
+	data "SocialMedia/Data"
+	"sort"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
 
 type FriendsRepository interface {
 	AddFriend(usernameSent, usernameRecieved string) error
 	GetFriendsList(username string) ([]string, error)
 	DeleteFriend(usernamesent, usernamereceived string) error
 	AcceptFriendRequest(usernameSent, usernameRecieved string) error
+	DeclineFriendRequest(usernameSent, usernameRecieved string) error
+	CancelFriendRequest(usernameSent, usernameRecieved string) error
+	GetFriendRequest(username, otherUsername string) (*data.FriendRequest, error)
+	GetIncomingRequests(username string) ([]data.FriendRequest, error)
+	GetOutgoingRequests(username string) ([]data.FriendRequest, error)
+	MigrateLegacyFriendships() error
+	BlockUser(username, blocked string) error
+	UnblockUser(username, blocked string) error
+	GetBlockedUsers(username string) ([]data.RestrictedUser, error)
+	MuteUser(username, muted string) error
+	UnmuteUser(username, muted string) error
+	GetMutedUsers(username string) ([]data.RestrictedUser, error)
 }
 
 type friendsRepository struct {
@@ -17,52 +34,215 @@ func NewFriendsRepository(driver neo4j.Driver) FriendsRepository {
 	return &friendsRepository{driver: driver}
 }
 
+// MigrateLegacyFriendships convierte las relaciones FRIEND anteriores a los
+// estados, que solo tenian la propiedad acepted, en relaciones con status
+// accepted o pending.
+func (graph *friendsRepository) MigrateLegacyFriendships() error {
+	return runMigration(graph.driver, "legacy_friendships", `
+        MATCH (:User)-[r:FRIEND]->(:User) WHERE r.status IS NULL
+        WITH r LIMIT $batchSize
+        SET r.status = CASE WHEN coalesce(r.acepted, false) THEN $accepted ELSE $pending END,
+            r.createdAt = coalesce(r.createdAt, timestamp()),
+            r.updatedAt = coalesce(r.updatedAt, timestamp())
+        REMOVE r.acepted
+        RETURN count(r) AS migrated`,
+		map[string]interface{}{
+			"accepted": data.FriendRequestAccepted,
+			"pending":  data.FriendRequestPending,
+		})
+}
+
+const friendRequestFields = `
+	sender.username AS from, receiver.username AS to, r.status AS status,
+	r.createdAt AS createdAt, r.updatedAt AS updatedAt`
+
+func friendRequestFromRecord(record *neo4j.Record) data.FriendRequest {
+	return data.FriendRequest{
+		From:      recordString(record, "from"),
+		To:        recordString(record, "to"),
+		Status:    recordString(record, "status"),
+		CreatedAt: recordTime(record, "createdAt"),
+		UpdatedAt: recordTime(record, "updatedAt"),
+	}
+}
+
+// AddFriend crea una solicitud pendiente de usernameSent a usernameRecieved
+// si este las acepta segun su configuracion de privacidad y ninguno ha
+// bloqueado al otro. Una solicitud anterior rechazada o cancelada se
+// reemplaza por la nueva.
 func (graph *friendsRepository) AddFriend(usernameSent, usernameRecieved string) error {
+	if usernameSent == usernameRecieved {
+		return ErrSelfFriendRequest
+	}
+
 	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close()
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
-		_, err := transaction.Run(
+		if err := lockUsers(transaction, usernameSent, usernameRecieved); err != nil {
+			return nil, err
+		}
+		if err := checkNotBlocked(transaction, usernameSent, usernameRecieved); err != nil {
+			return nil, err
+		}
+
+		existing, err := getFriendRequest(transaction, usernameSent, usernameRecieved)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			switch existing.Status {
+			case data.FriendRequestPending:
+				return nil, ErrFriendRequestExists
+			case data.FriendRequestAccepted:
+				return nil, ErrAlreadyFriends
+			}
+		}
+
+		if err := checkFriendRequestPolicy(transaction, usernameSent, usernameRecieved); err != nil {
+			return nil, err
+		}
+
+		result, err := transaction.Run(
 			`MATCH (u:User {username: $usernameSent})
 			 MATCH (u2:User {username: $usernameRecieved})
-			 MERGE (u)-[r:FRIEND]->(u2)
-       ON CREATE SET r.acepted = false`,
+			 OPTIONAL MATCH (u)-[old:FRIEND]-(u2)
+			 WITH u, u2, collect(old) AS olds
+			 FOREACH (old IN olds | DELETE old)
+			 CREATE (u)-[r:FRIEND {status: $status, createdAt: timestamp(), updatedAt: timestamp()}]->(u2)
+			 RETURN count(r) AS created`,
 			map[string]interface{}{
 				"usernameSent":     usernameSent,
 				"usernameRecieved": usernameRecieved,
+				"status":           data.FriendRequestPending,
 			},
 		)
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrUserNotFound
+		}
+		return nil, nil
 	})
 	return err
 }
 
+// lockUsers bloquea los nodos de los usuarios hasta el final de la
+// transaccion, para que dos solicitudes cruzadas no pasen a la vez las
+// comprobaciones. Se bloquean siempre en el mismo orden para no provocar
+// interbloqueos.
+func lockUsers(transaction neo4j.Transaction, usernames ...string) error {
+	sorted := append([]string(nil), usernames...)
+	sort.Strings(sorted)
+	result, err := transaction.Run(
+		`UNWIND $usernames AS username
+		 MATCH (u:User {username: username})
+		 SET u._lock = true
+		 REMOVE u._lock`,
+		map[string]interface{}{"usernames": sorted},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume()
+	return err
+}
+
+// checkFriendRequestPolicy comprueba que usernameRecieved acepta solicitudes
+// de usernameSent: de todos, solo de amigos de sus amigos o de nadie.
+func checkFriendRequestPolicy(transaction neo4j.Transaction, usernameSent, usernameRecieved string) error {
+	result, err := transaction.Run(
+		`MATCH (u2:User {username: $usernameRecieved})
+		 RETURN coalesce(u2.friendRequestPolicy, $everyone) AS policy,
+		        size([(u2)-[:FRIEND {status: $accepted}]-(:User)-[:FRIEND {status: $accepted}]-(:User {username: $usernameSent}) | 1]) > 0 AS mutual`,
+		map[string]interface{}{
+			"usernameSent":     usernameSent,
+			"usernameRecieved": usernameRecieved,
+			"everyone":         data.FriendRequestsEveryone,
+			"accepted":         data.FriendRequestAccepted,
+		})
+	if err != nil {
+		return err
+	}
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return err
+		}
+		return ErrUserNotFound
+	}
+
+	record := result.Record()
+	switch recordString(record, "policy") {
+	case data.FriendRequestsNobody:
+		return ErrFriendRequestsNotAllowed
+	case data.FriendRequestsFriendsOfFriends:
+		if !recordBool(record, "mutual") {
+			return ErrFriendRequestsNotAllowed
+		}
+	}
+	return nil
+}
+
 func (graph *friendsRepository) AcceptFriendRequest(usernameSent, usernameRecieved string) error {
+	return graph.resolveFriendRequest(usernameSent, usernameRecieved, data.FriendRequestAccepted)
+}
+
+func (graph *friendsRepository) DeclineFriendRequest(usernameSent, usernameRecieved string) error {
+	return graph.resolveFriendRequest(usernameSent, usernameRecieved, data.FriendRequestDeclined)
+}
+
+func (graph *friendsRepository) CancelFriendRequest(usernameSent, usernameRecieved string) error {
+	return graph.resolveFriendRequest(usernameSent, usernameRecieved, data.FriendRequestCancelled)
+}
+
+// resolveFriendRequest cambia el estado de una solicitud pendiente enviada
+// por usernameSent a usernameRecieved. Solo las solicitudes pendientes pueden
+// pasar a aceptada, rechazada o cancelada.
+func (graph *friendsRepository) resolveFriendRequest(usernameSent, usernameRecieved, status string) error {
 	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close()
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
-		_, err := transaction.Run(
-			`MATCH (u:User {username: $usernameSent})-[r:FRIEND]-(u2:User {username: $usernameRecieved})
-     SET r.acepted = true`,
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $usernameSent})-[r:FRIEND {status: $pending}]->(u2:User {username: $usernameRecieved})
+			 SET r.status = $status, r.updatedAt = timestamp()
+			 RETURN count(r) AS updated`,
 			map[string]interface{}{
 				"usernameSent":     usernameSent,
 				"usernameRecieved": usernameRecieved,
+				"pending":          data.FriendRequestPending,
+				"status":           status,
 			})
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		if recordInt(record, "updated") == 0 {
+			return nil, ErrFriendRequestNotFound
+		}
+		return nil, nil
 	})
 	return err
 }
 
+// GetFriendsList devuelve solo las amistades aceptadas.
 func (graph *friendsRepository) GetFriendsList(username string) ([]string, error) {
 	session := graph.driver.NewSession(neo4j.SessionConfig{})
 	defer session.Close()
 
 	query := `
-        MATCH (u:User {username: $username})-[:FRIEND]-(friend:User)
+        MATCH (u:User {username: $username})-[r:FRIEND {status: $accepted}]-(friend:User)
         RETURN friend.username AS friendUsername
     `
 
 	result, err := session.Run(query, map[string]interface{}{
 		"username": username,
+		"accepted": data.FriendRequestAccepted,
 	})
 	if err != nil {
 		return nil, err
@@ -78,19 +258,114 @@ func (graph *friendsRepository) GetFriendsList(username string) ([]string, error
 	return friends, nil
 }
 
+// GetFriendRequest devuelve la relacion entre los dos usuarios en cualquier
+// direccion, o nil si no existe.
+func (graph *friendsRepository) GetFriendRequest(username, otherUsername string) (*data.FriendRequest, error) {
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		return getFriendRequest(transaction, username, otherUsername)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.FriendRequest), nil
+}
+
+func getFriendRequest(transaction neo4j.Transaction, username, otherUsername string) (*data.FriendRequest, error) {
+	result, err := transaction.Run(
+		`MATCH (:User {username: $username})-[r:FRIEND]-(:User {username: $otherUsername})
+		 WITH r, startNode(r) AS sender, endNode(r) AS receiver
+		 RETURN `+friendRequestFields+`
+		 ORDER BY r.updatedAt DESC
+		 LIMIT 1`,
+		map[string]interface{}{
+			"username":      username,
+			"otherUsername": otherUsername,
+		})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Next() {
+		return nil, result.Err()
+	}
+	request := friendRequestFromRecord(result.Record())
+	return &request, nil
+}
+
+// GetIncomingRequests devuelve las solicitudes pendientes recibidas por username.
+func (graph *friendsRepository) GetIncomingRequests(username string) ([]data.FriendRequest, error) {
+	return graph.getPendingRequests(
+		`MATCH (sender:User)-[r:FRIEND {status: $pending}]->(receiver:User {username: $username})`,
+		username)
+}
+
+// GetOutgoingRequests devuelve las solicitudes pendientes enviadas por username.
+func (graph *friendsRepository) GetOutgoingRequests(username string) ([]data.FriendRequest, error) {
+	return graph.getPendingRequests(
+		`MATCH (sender:User {username: $username})-[r:FRIEND {status: $pending}]->(receiver:User)`,
+		username)
+}
+
+func (graph *friendsRepository) getPendingRequests(match, username string) ([]data.FriendRequest, error) {
+	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			match+`
+			 RETURN `+friendRequestFields+`
+			 ORDER BY r.createdAt DESC`,
+			map[string]interface{}{
+				"username": username,
+				"pending":  data.FriendRequestPending,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		requests := []data.FriendRequest{}
+		for result.Next() {
+			requests = append(requests, friendRequestFromRecord(result.Record()))
+		}
+		if err = result.Err(); err != nil {
+			return nil, err
+		}
+		return requests, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]data.FriendRequest), nil
+}
+
+// DeleteFriend elimina una amistad aceptada, en cualquier direccion.
 func (graph *friendsRepository) DeleteFriend(usernameSent, usernameRecieved string) error {
 	session := graph.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close()
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
-		_, err := transaction.Run(
-			`MATCH (u:User {username: $usernameSent})-[f:FRIEND]-(u2:User {username: $usernameRecieved})
-						 DELETE f`,
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $usernameSent})-[f:FRIEND {status: $accepted}]-(u2:User {username: $usernameRecieved})
+			 DELETE f
+			 RETURN count(f) AS deleted`,
 			map[string]interface{}{
 				"usernameSent":     usernameSent,
 				"usernameRecieved": usernameRecieved,
+				"accepted":         data.FriendRequestAccepted,
 			},
 		)
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		if recordInt(record, "deleted") == 0 {
+			return nil, ErrNotFriends
+		}
+		return nil, nil
 	})
 	return err
 }
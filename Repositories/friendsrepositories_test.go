@@ -0,0 +1,64 @@
+package Repositories
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func TestAddFriendCrossedRequests(t *testing.T) {
+	driver, prefix := newTestDriver(t)
+	users := NewUserRepository(driver)
+	friends := NewFriendsRepository(driver)
+
+	alice, bob := prefix+"alice", prefix+"bob"
+	for _, username := range []string{alice, bob} {
+		if err := users.CreateUser(username, "hash", username+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	// Si los dos se envian una solicitud a la vez solo puede crearse una.
+	pairs := [][2]string{{alice, bob}, {bob, alice}}
+	errs := make([]error, len(pairs))
+	var wg sync.WaitGroup
+	for i, pair := range pairs {
+		wg.Add(1)
+		go func(i int, from, to string) {
+			defer wg.Done()
+			errs[i] = friends.AddFriend(from, to)
+		}(i, pair[0], pair[1])
+	}
+	wg.Wait()
+
+	var refused int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrFriendRequestExists):
+			refused++
+		case err != nil:
+			t.Fatalf("AddFriend: %v", err)
+		}
+	}
+	if refused != 1 {
+		t.Fatalf("AddFriend rechazado %d veces, se esperaba 1", refused)
+	}
+
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+	result, err := session.Run(
+		`MATCH (:User {username: $alice})-[f:FRIEND]-(:User {username: $bob}) RETURN count(f) AS requests`,
+		map[string]interface{}{"alice": alice, "bob": bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := result.Single()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := recordInt(record, "requests"); n != 1 {
+		t.Errorf("%d solicitudes entre alice y bob, se esperaba 1", n)
+	}
+}
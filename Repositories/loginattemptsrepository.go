@@ -0,0 +1,255 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"sync"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// LoginAttemptRepository cuenta los inicios de sesion fallidos seguidos por
+// clave (usuario o IP) y guarda los eventos de auditoria. Los fallos
+// anteriores a since se olvidan.
+type LoginAttemptRepository interface {
+	// ReserveAttempt cuenta un intento en key como fallido antes de
+	// comprobar las credenciales, si delay(fallos) ya ha pasado desde el
+	// ultimo fallo. Devuelve los fallos contando este intento, o cuanto hay
+	// que esperar si no se ha contado. La comprobacion y el incremento son
+	// atomicos, asi que los intentos simultaneos no pasan todos a la vez.
+	ReserveAttempt(key string, at, since time.Time, delay func(failures int) time.Duration) (int, time.Duration, error)
+	// ReleaseAttempt descuenta el intento reservado en at que no ha fallado y,
+	// si no hay otra reserva posterior, devuelve el ultimo fallo al que habia
+	// antes, para que los intentos correctos no alarguen la espera.
+	ReleaseAttempt(key string, at time.Time) error
+	ResetFailures(key string) error
+	DeleteStaleAttempts(since time.Time) error
+	AddAuditEvent(event data.AuditEvent) error
+	DeleteAuditEvents(before time.Time) error
+}
+
+type loginAttemptRepository struct {
+	driver neo4j.Driver
+}
+
+func NewLoginAttemptRepository(driver neo4j.Driver) LoginAttemptRepository {
+	return &loginAttemptRepository{driver}
+}
+
+// ReserveAttempt bloquea el nodo de key con el primer SET hasta el final de
+// la transaccion, asi que las reservas de una misma clave se serializan. La
+// restriccion de unicidad de LoginAttempts.key (ver db.EnsureSchema) evita
+// que dos MERGE simultaneos creen dos nodos.
+func (r *loginAttemptRepository) ReserveAttempt(key string, at, since time.Time, delay func(failures int) time.Duration) (int, time.Duration, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	var failures int
+	var wait time.Duration
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MERGE (a:LoginAttempts {key: $key})
+			 SET a.lastAttempt = $at
+			 RETURN CASE WHEN coalesce(a.lastFailure, 0) < $since THEN 0 ELSE a.failures END AS failures,
+			        a.lastFailure AS lastFailure`,
+			map[string]interface{}{
+				"key":   key,
+				"at":    at.UnixMilli(),
+				"since": since.UnixMilli(),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		failures = int(recordInt(record, "failures"))
+		wait = recordTime(record, "lastFailure").Add(delay(failures)).Sub(at)
+		if wait > 0 {
+			return nil, nil
+		}
+
+		wait = 0
+		failures++
+		_, err = transaction.Run(
+			`MATCH (a:LoginAttempts {key: $key})
+			 SET a.failures = $failures, a.previousFailure = a.lastFailure, a.lastFailure = $at`,
+			map[string]interface{}{"key": key, "failures": failures, "at": at.UnixMilli()},
+		)
+		return nil, err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return failures, wait, nil
+}
+
+// ReleaseAttempt solo restaura el ultimo fallo si sigue siendo el de esta
+// reserva; si se ha reservado otro intento despues, el suyo se mantiene.
+func (r *loginAttemptRepository) ReleaseAttempt(key string, at time.Time) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`MATCH (a:LoginAttempts {key: $key}) WHERE a.failures > 0
+			 SET a.failures = a.failures - 1
+			 WITH a WHERE a.lastFailure = $at
+			 SET a.lastFailure = a.previousFailure
+			 REMOVE a.previousFailure`,
+			map[string]interface{}{"key": key, "at": at.UnixMilli()},
+		)
+		return nil, err
+	})
+	return err
+}
+
+func (r *loginAttemptRepository) ResetFailures(key string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`MATCH (a:LoginAttempts {key: $key}) DELETE a`,
+			map[string]interface{}{"key": key},
+		)
+		return nil, err
+	})
+	return err
+}
+
+// DeleteStaleAttempts borra los contadores sin fallos desde since, que ya
+// se han olvidado.
+func (r *loginAttemptRepository) DeleteStaleAttempts(since time.Time) error {
+	return deleteInBatches(r.driver,
+		`MATCH (a:LoginAttempts) WHERE coalesce(a.lastFailure, 0) < $since
+		 WITH a LIMIT $batchSize
+		 DELETE a
+		 RETURN count(*) AS deleted`,
+		map[string]interface{}{"since": since.UnixMilli()})
+}
+
+func (r *loginAttemptRepository) AddAuditEvent(event data.AuditEvent) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`CREATE (:AuditEvent {type: $type, username: $username, ip: $ip, reason: $reason, createdAt: $createdAt})`,
+			map[string]interface{}{
+				"type":      event.Type,
+				"username":  event.Username,
+				"ip":        event.IP,
+				"reason":    event.Reason,
+				"createdAt": event.CreatedAt.UnixMilli(),
+			},
+		)
+		return nil, err
+	})
+	return err
+}
+
+// DeleteAuditEvents borra los eventos de auditoria anteriores a before.
+func (r *loginAttemptRepository) DeleteAuditEvents(before time.Time) error {
+	return deleteInBatches(r.driver,
+		`MATCH (e:AuditEvent) WHERE e.createdAt < $before
+		 WITH e LIMIT $batchSize
+		 DELETE e
+		 RETURN count(*) AS deleted`,
+		map[string]interface{}{"before": before.UnixMilli()})
+}
+
+type loginAttempts struct {
+	failures        int
+	lastFailure     time.Time
+	previousFailure time.Time
+}
+
+type inMemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]loginAttempts
+	events   []data.AuditEvent
+}
+
+// NewInMemoryLoginAttemptRepository devuelve un LoginAttemptRepository que
+// no necesita Neo4j, pensado para tests y desarrollo local.
+func NewInMemoryLoginAttemptRepository() LoginAttemptRepository {
+	return &inMemoryLoginAttemptRepository{attempts: make(map[string]loginAttempts)}
+}
+
+func (r *inMemoryLoginAttemptRepository) ReserveAttempt(key string, at, since time.Time, delay func(failures int) time.Duration) (int, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts := r.attempts[key]
+	if attempts.lastFailure.Before(since) {
+		attempts.failures = 0
+	}
+	if wait := attempts.lastFailure.Add(delay(attempts.failures)).Sub(at); wait > 0 {
+		return attempts.failures, wait, nil
+	}
+	attempts.failures++
+	attempts.previousFailure = attempts.lastFailure
+	attempts.lastFailure = at
+	r.attempts[key] = attempts
+	return attempts.failures, 0, nil
+}
+
+func (r *inMemoryLoginAttemptRepository) ReleaseAttempt(key string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attempts, ok := r.attempts[key]; ok && attempts.failures > 0 {
+		attempts.failures--
+		if attempts.lastFailure.Equal(at) {
+			attempts.lastFailure = attempts.previousFailure
+			attempts.previousFailure = time.Time{}
+		}
+		r.attempts[key] = attempts
+	}
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) DeleteStaleAttempts(since time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, attempts := range r.attempts {
+		if attempts.lastFailure.Before(since) {
+			delete(r.attempts, key)
+		}
+	}
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) ResetFailures(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) AddAuditEvent(event data.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) DeleteAuditEvents(before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.events[:0]
+	for _, event := range r.events {
+		if !event.CreatedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	r.events = kept
+	return nil
+}
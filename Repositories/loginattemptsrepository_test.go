@@ -0,0 +1,86 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"testing"
+	"time"
+)
+
+func TestInMemoryReserveAttempt(t *testing.T) {
+	repo := NewInMemoryLoginAttemptRepository()
+	now := time.Now()
+	since := now.Add(-time.Hour)
+	delay := func(failures int) time.Duration {
+		if failures < 2 {
+			return 0
+		}
+		return time.Minute
+	}
+
+	for want := 1; want <= 2; want++ {
+		failures, wait, err := repo.ReserveAttempt("user:alice", now, since, delay)
+		if err != nil || wait != 0 || failures != want {
+			t.Fatalf("reserva %d: failures %d, wait %v, err %v", want, failures, wait, err)
+		}
+	}
+	if _, wait, _ := repo.ReserveAttempt("user:alice", now.Add(time.Second), since, delay); wait != time.Minute-time.Second {
+		t.Fatalf("wait = %v, se esperaba %v", wait, time.Minute-time.Second)
+	}
+
+	repo.ReleaseAttempt("user:alice", now)
+	if failures, wait, _ := repo.ReserveAttempt("user:alice", now, since, delay); wait != 0 || failures != 2 {
+		t.Fatalf("tras liberar: failures %d, wait %v", failures, wait)
+	}
+
+	// Los fallos anteriores a since se olvidan.
+	if failures, wait, _ := repo.ReserveAttempt("user:alice", now.Add(2*time.Hour), now.Add(time.Hour), delay); wait != 0 || failures != 1 {
+		t.Fatalf("tras la ventana: failures %d, wait %v", failures, wait)
+	}
+}
+
+// Un intento correcto no cuenta como fallo ni mueve la espera: tras
+// liberarlo el ultimo fallo vuelve a ser el de antes.
+func TestInMemoryReleaseAttemptRestoresLastFailure(t *testing.T) {
+	repo := NewInMemoryLoginAttemptRepository()
+	now := time.Now()
+	since := now.Add(-time.Hour)
+	delay := func(failures int) time.Duration { return time.Duration(failures) * time.Minute }
+
+	if _, _, err := repo.ReserveAttempt("ip:10.0.0.1", now, since, delay); err != nil {
+		t.Fatal(err)
+	}
+	correct := now.Add(time.Minute)
+	if _, wait, _ := repo.ReserveAttempt("ip:10.0.0.1", correct, since, delay); wait != 0 {
+		t.Fatalf("wait = %v, se esperaba 0", wait)
+	}
+	repo.ReleaseAttempt("ip:10.0.0.1", correct)
+
+	// Con un fallo la espera es de un minuto desde now, no desde correct.
+	if failures, wait, _ := repo.ReserveAttempt("ip:10.0.0.1", correct.Add(time.Second), since, delay); wait != 0 || failures != 2 {
+		t.Fatalf("tras liberar: failures %d, wait %v", failures, wait)
+	}
+}
+
+func TestInMemoryLoginAttemptCleanup(t *testing.T) {
+	repo := NewInMemoryLoginAttemptRepository().(*inMemoryLoginAttemptRepository)
+	now := time.Now()
+	noDelay := func(int) time.Duration { return 0 }
+
+	repo.ReserveAttempt("user:old", now.Add(-48*time.Hour), now.Add(-72*time.Hour), noDelay)
+	repo.ReserveAttempt("user:new", now, now.Add(-time.Hour), noDelay)
+	repo.AddAuditEvent(data.AuditEvent{Type: data.AuditLoginFailed, CreatedAt: now.Add(-100 * 24 * time.Hour)})
+	repo.AddAuditEvent(data.AuditEvent{Type: data.AuditLoginFailed, CreatedAt: now})
+
+	repo.DeleteStaleAttempts(now.Add(-24 * time.Hour))
+	repo.DeleteAuditEvents(now.Add(-90 * 24 * time.Hour))
+
+	if _, ok := repo.attempts["user:old"]; ok {
+		t.Error("no se borro el contador olvidado")
+	}
+	if _, ok := repo.attempts["user:new"]; !ok {
+		t.Error("se borro el contador reciente")
+	}
+	if len(repo.events) != 1 || !repo.events[0].CreatedAt.Equal(now) {
+		t.Errorf("eventos = %+v, se esperaba solo el reciente", repo.events)
+	}
+}
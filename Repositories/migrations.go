@@ -0,0 +1,108 @@
+package Repositories
+
+import (
+	"log"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+const migrationBatchSize = 1000
+
+// runMigration ejecuta query por lotes de $batchSize hasta que no migra nada
+// y apunta la migracion como hecha con un nodo (:Migration {name}), para no
+// volver a recorrer los datos en cada arranque. query debe devolver en la
+// columna migrated cuantos elementos ha migrado en el lote.
+func runMigration(driver neo4j.Driver, name, query string, params map[string]interface{}) error {
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	done, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (m:Migration {name: $name}) RETURN count(m) > 0 AS done`,
+			map[string]interface{}{"name": name})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		return recordBool(record, "done"), nil
+	})
+	if err != nil {
+		return err
+	}
+	if done.(bool) {
+		return nil
+	}
+
+	batchParams := map[string]interface{}{"batchSize": migrationBatchSize}
+	for key, value := range params {
+		batchParams[key] = value
+	}
+
+	var total int64
+	for {
+		migrated, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+			result, err := transaction.Run(query, batchParams)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+			return recordInt(record, "migrated"), nil
+		})
+		if err != nil {
+			return err
+		}
+		if migrated.(int64) == 0 {
+			break
+		}
+		total += migrated.(int64)
+	}
+	if total > 0 {
+		log.Printf("Migracion %s: %d elementos migrados", name, total)
+	}
+
+	_, err = session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`MERGE (m:Migration {name: $name}) ON CREATE SET m.completedAt = timestamp()`,
+			map[string]interface{}{"name": name})
+		return nil, err
+	})
+	return err
+}
+
+// deleteInBatches ejecuta query, que debe borrar como mucho $batchSize
+// elementos y devolver cuantos en la columna deleted, hasta que no borra
+// nada. Asi una limpieza grande no se hace en una sola transaccion.
+func deleteInBatches(driver neo4j.Driver, query string, params map[string]interface{}) error {
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	batchParams := map[string]interface{}{"batchSize": migrationBatchSize}
+	for key, value := range params {
+		batchParams[key] = value
+	}
+	for {
+		deleted, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+			result, err := transaction.Run(query, batchParams)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+			return recordInt(record, "deleted"), nil
+		})
+		if err != nil {
+			return err
+		}
+		if deleted.(int64) == 0 {
+			return nil
+		}
+	}
+}
@@ -2,17 +2,40 @@ package Repositories
 
 import (
 	data "SocialMedia/Data"
+	"encoding/base64"
+	"encoding/json"
 	"log"
+	"sort"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 )
 
 type PostsRepository interface {
 	CreatePost(username string, post data.Post) error
-	GetUserPost(username string) ([]data.Post, error)
-	DeletePost(username, postID string) error
+	GetUserPost(viewer, username string) ([]data.Post, error)
+	CheckPostVisible(viewer, postID string) error
+	GetFeed(username, cursor string, limit int) ([]data.Post, string, error)
+	UpdatePost(username, postID, content string) (*data.Post, error)
+	SetPostVisibility(username, postID, visibility string, visibleTo []string) (*data.Post, error)
+	GetPostRevisions(username, postID string) ([]data.PostRevision, error)
+	DeletePost(username, postID string) ([]string, error)
 	LikePost(username, postID string) error
-	GetLikesFromPost(postId string) ([]string, error)
+	UnlikePost(username, postID string) error
+	GetLikesFromPost(viewer, postId string) ([]string, error)
+	SetReaction(username, postID, reactionType string) error
+	RemoveReaction(username, postID, reactionType string) error
+	GetReactions(viewer, postID, reactionType string) ([]data.Reaction, error)
+	MigrateLegacyLikes() error
+	MigrateLegacyComments() error
+	MigrateLegacyPostTimestamps() error
+	CreateComment(username string, comment data.Comment) (*data.Comment, error)
+	GetComments(viewer, postID, parentID string, skip, limit int) ([]data.Comment, error)
+	UpdateComment(username, commentID, content string) (*data.Comment, error)
+	DeleteComment(username, commentID string) error
+	ModeratePost(postID string) ([]string, error)
+	ModerateComment(commentID string) error
 }
 
 type postsRepository struct {
@@ -23,39 +46,253 @@ func NewPostsRepository(driver neo4j.Driver) PostsRepository {
 	return &postsRepository{driver}
 }
 
+// CreatePost crea el post y sus adjuntos en orden como
+// (:Post)-[:HAS_MEDIA {position}]->(:Media).
 func (r *postsRepository) CreatePost(username string, post data.Post) error {
 	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close()
 
+	media := make([]map[string]interface{}, 0, len(post.Media))
+	for i, m := range post.Media {
+		renditions := make([]map[string]interface{}, 0, len(m.Renditions))
+		for _, rendition := range m.Renditions {
+			renditions = append(renditions, map[string]interface{}{
+				"name":   rendition.Name,
+				"key":    rendition.Key,
+				"url":    rendition.URL,
+				"width":  rendition.Width,
+				"height": rendition.Height,
+			})
+		}
+		media = append(media, map[string]interface{}{
+			"position":    i,
+			"id":          m.ID,
+			"key":         m.Key,
+			"url":         m.URL,
+			"contentType": m.ContentType,
+			"size":        m.Size,
+			"width":       m.Width,
+			"height":      m.Height,
+			"renditions":  renditions,
+		})
+	}
+
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
 		_, err := transaction.Run(
 			`MATCH (u:User {username: $username})
-             CREATE (p:Post {id: $id, content: $content, likes: $likes, comments: $comments, ImageURL: $imageURL})
-             CREATE (u)-[:POSTED]->(p)`,
+             CREATE (p:Post {id: $id, content: $content, visibility: $visibility,
+                             createdAt: $createdAt, updatedAt: $createdAt})
+             CREATE (u)-[:POSTED]->(p)
+             WITH p
+             UNWIND $media AS m
+             CREATE (p)-[:HAS_MEDIA {position: m.position}]->(media:Media {
+                 id: m.id, key: m.key, url: m.url, contentType: m.contentType,
+                 size: m.size, width: m.width, height: m.height
+             })
+             FOREACH (rd IN m.renditions |
+                 CREATE (media)-[:HAS_RENDITION]->(:Rendition {
+                     name: rd.name, key: rd.key, url: rd.url, width: rd.width, height: rd.height
+                 }))`,
 			map[string]interface{}{
-				"username": username,
-				"id":       post.ID,
-				"content":  post.Content,
-				"likes":    post.Likes,
-				"comments": post.Comments,
-				"imageURL": post.ImageURL,
+				"username":   username,
+				"id":         post.ID,
+				"content":    post.Content,
+				"visibility": post.Visibility,
+				"createdAt":  post.CreatedAt.UnixMilli(),
+				"media":      media,
 			},
 		)
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		return nil, setVisibleTo(transaction, post.ID, post.VisibleTo)
 	})
 
 	return err
 }
 
-func (r *postsRepository) GetUserPost(username string) ([]data.Post, error) {
+// setVisibleTo reemplaza los usuarios que pueden ver el post custom postID.
+// Los usernames que no existen se ignoran.
+func setVisibleTo(tx neo4j.Transaction, postID string, usernames []string) error {
+	_, err := tx.Run(`
+        MATCH (p:Post {id: $postID})
+        OPTIONAL MATCH (p)-[old:VISIBLE_TO]->(:User)
+        DELETE old
+        WITH DISTINCT p
+        UNWIND $usernames AS username
+        MATCH (u:User {username: username})
+        MERGE (p)-[:VISIBLE_TO]->(u)`,
+		map[string]interface{}{"postID": postID, "usernames": usernames})
+	return err
+}
+
+// visiblePostCondition es la condicion para que $viewer pueda ver el post p
+// de author. El autor ve siempre sus posts. Los amigos ven los public,
+// friends y friends_of_friends, y el resto de usuarios los public y, si
+// tienen un amigo en comun, los friends_of_friends, salvo que el autor tenga
+// el perfil privado. Los posts custom los ven los usuarios de VISIBLE_TO y
+// los only_me solo el autor. Si uno de los dos ha bloqueado al otro no se ve
+// ningun post, y los de cuentas con el borrado programado solo los ve su
+// autor durante el periodo de gracia.
+const visiblePostCondition = `(
+	author.username = $viewer
+	OR (` + authorNotBlocked + ` AND author.deleteAfter IS NULL AND (
+		(coalesce(p.visibility, 'public') = 'custom'
+			AND size([(p)-[:VISIBLE_TO]->(:User {username: $viewer}) | 1]) > 0)
+		OR (coalesce(p.visibility, 'public') IN ['public', 'friends', 'friends_of_friends']
+			AND size([(author)-[:FRIEND {status: 'accepted'}]-(:User {username: $viewer}) | 1]) > 0)
+		OR (NOT coalesce(author.privateProfile, false)
+			AND (coalesce(p.visibility, 'public') = 'public'
+				OR (coalesce(p.visibility, 'public') = 'friends_of_friends'
+					AND size([(author)-[:FRIEND {status: 'accepted'}]-(:User)-[:FRIEND {status: 'accepted'}]-(:User {username: $viewer}) | 1]) > 0)))))
+)`
+
+// Condiciones de que ni el usuario enlazado a author, reactor o commenter ni
+// $viewer han bloqueado al otro.
+const (
+	authorNotBlocked    = `size([(author)-[:BLOCKED]-(:User {username: $viewer}) | 1]) = 0`
+	reactorNotBlocked   = `size([(reactor)-[:BLOCKED]-(:User {username: $viewer}) | 1]) = 0`
+	commenterNotBlocked = `size([(commenter)-[:BLOCKED]-(:User {username: $viewer}) | 1]) = 0`
+)
+
+// visibleCommentCondition es la condicion para que $viewer vea un comentario
+// escrito por author: ninguno ha bloqueado al otro y la cuenta de author no
+// tiene el borrado programado. Los comentarios sin autor se ven siempre.
+const visibleCommentCondition = `(author IS NULL OR (` + authorNotBlocked + `
+	AND (author.deleteAfter IS NULL OR author.username = $viewer)))`
+
+// visibleCommentWriter es la misma condicion para el comentario enlazado a
+// comment, que se usa al contar comentarios y respuestas.
+const visibleCommentWriter = `all(commenter IN [(comment)<-[:WROTE]-(w:User) | w] WHERE ` + commenterNotBlocked + `
+		AND (commenter.deleteAfter IS NULL OR commenter.username = $viewer))`
+
+// postFields son las columnas que devuelven todas las consultas de posts. Las
+// consultas deben enlazar p y author y recibir el parametro $viewer con el
+// usuario que hace la peticion. Los likes y el resumen de reacciones se
+// calculan a partir de las relaciones REACTED; ni ellos ni commentCount
+// cuentan a los usuarios bloqueados con $viewer.
+const postFields = `
+	p.id AS id, author.username AS author, p.content AS content,
+	` + reactionCounts + ` AS reactionCounts,
+	head([(p)<-[r:REACTED]-(:User {username: $viewer}) | r.type]) AS myReaction,
+	p.ImageURL AS imageURL,
+	[(p)-[hm:HAS_MEDIA]->(m:Media) | {
+		position: hm.position, id: m.id, url: m.url, contentType: m.contentType,
+		size: m.size, width: m.width, height: m.height,
+		renditions: [(m)-[:HAS_RENDITION]->(rd:Rendition) | {
+			name: rd.name, url: rd.url, width: rd.width, height: rd.height
+		}]
+	}] AS media,
+	p.createdAt AS createdAt, coalesce(p.updatedAt, p.createdAt) AS updatedAt,
+	size([(p)<-[:ON]-(comment:Comment) WHERE ` + visibleCommentWriter + ` | 1]) AS commentCount,
+	coalesce(p.visibility, 'public') AS visibility,
+	CASE WHEN author.username = $viewer THEN [(p)-[:VISIBLE_TO]->(v:User) | v.username] ELSE [] END AS visibleTo`
+
+// reactionCounts devuelve un par [tipo, total] por cada tipo de reaccion de
+// data.ReactionTypes. Se cuenta en la base de datos para no leer cada
+// reaccion del post.
+const reactionCounts = `[t IN ['` + data.ReactionLike + `', '` + data.ReactionLove + `', '` + data.ReactionLaugh + `', '` +
+	data.ReactionWow + `', '` + data.ReactionSad + `', '` + data.ReactionAngry + `'] |
+		[t, size([(p)<-[:REACTED {type: t}]-(reactor:User) WHERE ` + reactorNotBlocked + ` | 1])]]`
+
+func postFromRecord(record *neo4j.Record) data.Post {
+	reactions := map[string]int{}
+	if values, ok := record.Get("reactionCounts"); ok && values != nil {
+		for _, value := range values.([]interface{}) {
+			pair, ok := value.([]interface{})
+			if !ok || len(pair) != 2 {
+				continue
+			}
+			reactionType, _ := pair[0].(string)
+			if count, _ := pair[1].(int64); count > 0 {
+				reactions[reactionType] = int(count)
+			}
+		}
+	}
+	myReaction := recordString(record, "myReaction")
+
+	return data.Post{
+		ID:           recordString(record, "id"),
+		Author:       recordString(record, "author"),
+		Content:      recordString(record, "content"),
+		Likes:        reactions[data.ReactionLike],
+		LikedByMe:    myReaction == data.ReactionLike,
+		Reactions:    reactions,
+		MyReaction:   myReaction,
+		CommentCount: int(recordInt(record, "commentCount")),
+		Media:        mediaFromRecord(record),
+		CreatedAt:    recordTime(record, "createdAt"),
+		UpdatedAt:    recordTime(record, "updatedAt"),
+		Visibility:   recordString(record, "visibility"),
+		VisibleTo:    recordStrings(record, "visibleTo"),
+	}
+}
+
+// mediaFromRecord devuelve los adjuntos del post ordenados por posicion.
+// Los posts anteriores a los adjuntos solo tienen la propiedad ImageURL.
+func mediaFromRecord(record *neo4j.Record) []data.Media {
+	values, _ := record.Get("media")
+	items, _ := values.([]interface{})
+	sort.Slice(items, func(i, j int) bool {
+		return mediaPosition(items[i]) < mediaPosition(items[j])
+	})
+
+	media := []data.Media{}
+	for _, item := range items {
+		props, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		m := data.Media{}
+		m.ID, _ = props["id"].(string)
+		m.URL, _ = props["url"].(string)
+		m.ContentType, _ = props["contentType"].(string)
+		m.Size, _ = props["size"].(int64)
+		width, _ := props["width"].(int64)
+		height, _ := props["height"].(int64)
+		m.Width, m.Height = int(width), int(height)
+
+		renditions, _ := props["renditions"].([]interface{})
+		for _, value := range renditions {
+			rendition, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			rd := data.Rendition{}
+			rd.Name, _ = rendition["name"].(string)
+			rd.URL, _ = rendition["url"].(string)
+			width, _ := rendition["width"].(int64)
+			height, _ := rendition["height"].(int64)
+			rd.Width, rd.Height = int(width), int(height)
+			m.Renditions = append(m.Renditions, rd)
+		}
+		media = append(media, m)
+	}
+
+	if imageURL := recordString(record, "imageURL"); len(media) == 0 && imageURL != "" {
+		media = append(media, data.Media{URL: imageURL})
+	}
+	return media
+}
+
+func mediaPosition(item interface{}) int64 {
+	props, _ := item.(map[string]interface{})
+	position, _ := props["position"].(int64)
+	return position
+}
+
+// GetUserPost devuelve los posts de username que puede ver viewer.
+func (r *postsRepository) GetUserPost(viewer, username string) ([]data.Post, error) {
 	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
 	defer session.Close()
 
 	query := `
-		MATCH (u:User {username: $username})-[:POSTED]->(p:Post)
-		RETURN p.id AS ID, p.content AS content, p.likes AS likes, p.comments AS comments, p.ImageURL AS imageURL
+		MATCH (author:User {username: $username})-[:POSTED]->(p:Post)
+		WHERE ` + visiblePostCondition + `
+		RETURN ` + postFields + `
+		ORDER BY coalesce(p.createdAt, 0) DESC, p.id DESC
 	`
-	params := map[string]interface{}{"username": username}
+	params := map[string]interface{}{"username": username, "viewer": viewer}
 
 	var posts []data.Post
 	result, err := session.Run(query, params)
@@ -64,136 +301,793 @@ func (r *postsRepository) GetUserPost(username string) ([]data.Post, error) {
 	}
 
 	for result.Next() {
-		record := result.Record()
+		posts = append(posts, postFromRecord(result.Record()))
+	}
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
 
-		var id string
-		var content, imageURL string
-		var likes int64
-		var commentsSlice []string
+// feedCursor marca el ultimo post de una pagina del feed. Se envia al cliente
+// codificado en base64 para que sea opaco.
+type feedCursor struct {
+	CreatedAt int64  `json:"t"`
+	ID        string `json:"id"`
+}
 
-		if IDValue, ok := record.Get("ID"); ok && IDValue != nil {
-			id = IDValue.(string)
+// encodeFeedCursor codifica el cursor del post. Los posts sin fecha valen 0,
+// igual que coalesce(p.createdAt, 0) en GetFeed.
+func encodeFeedCursor(post data.Post) string {
+	var createdAt int64
+	if !post.CreatedAt.IsZero() {
+		createdAt = post.CreatedAt.UnixMilli()
+	}
+	cursor, _ := json.Marshal(feedCursor{CreatedAt: createdAt, ID: post.ID})
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+func decodeFeedCursor(value string) (*feedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor feedCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// GetFeed devuelve los posts de los amigos de username que puede ver y no ha
+// silenciado, del mas nuevo al mas antiguo, de limit en limit. cursor es el
+// valor devuelto por la pagina anterior (vacio para la primera); el cursor
+// devuelto es vacio si no hay mas posts.
+func (r *postsRepository) GetFeed(username, cursor string, limit int) ([]data.Post, string, error) {
+	params := map[string]interface{}{
+		"username":   username,
+		"viewer":     username,
+		"accepted":   data.FriendRequestAccepted,
+		"cursorTime": nil,
+		"cursorID":   "",
+		"limit":      limit + 1,
+	}
+	if cursor != "" {
+		decoded, err := decodeFeedCursor(cursor)
+		if err != nil {
+			return nil, "", err
 		}
+		params["cursorTime"] = decoded.CreatedAt
+		params["cursorID"] = decoded.ID
+	}
 
-		if contentValue, ok := record.Get("content"); ok && contentValue != nil {
-			content = contentValue.(string)
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(`
+            MATCH (me:User {username: $username})-[:FRIEND {status: $accepted}]-(author:User)-[:POSTED]->(p:Post)
+            WHERE `+visiblePostCondition+`
+              AND size([(me)-[:MUTED]->(author) | 1]) = 0
+            WITH DISTINCT author, p, coalesce(p.createdAt, 0) AS createdAt
+            WHERE $cursorTime IS NULL
+               OR createdAt < $cursorTime
+               OR (createdAt = $cursorTime AND p.id < $cursorID)
+            RETURN `+postFields+`
+            ORDER BY createdAt DESC, p.id DESC
+            LIMIT $limit`, params)
+		if err != nil {
+			return nil, err
 		}
 
-		if likesValue, ok := record.Get("likes"); ok && likesValue != nil {
-			likes = likesValue.(int64)
+		posts := []data.Post{}
+		for result.Next() {
+			posts = append(posts, postFromRecord(result.Record()))
 		}
+		if err = result.Err(); err != nil {
+			return nil, err
+		}
+		return posts, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
 
-		if commentsValue, ok := record.Get("comments"); ok && commentsValue != nil {
-			commentsInterfaceSlice := commentsValue.([]interface{})
-			for _, comment := range commentsInterfaceSlice {
-				if commentStr, ok := comment.(string); ok {
-					commentsSlice = append(commentsSlice, commentStr)
-				}
+	posts := result.([]data.Post)
+	nextCursor := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		nextCursor = encodeFeedCursor(posts[limit-1])
+	}
+	return posts, nextCursor, nil
+}
+
+// UpdatePost cambia el contenido de un post de username. El contenido
+// anterior se guarda como (:Post)-[:PREVIOUS_VERSION]->(:PostRevision). Un
+// post sin adjuntos no se puede dejar vacio (ErrEmptyPost).
+func (r *postsRepository) UpdatePost(username, postID, content string) (*data.Post, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostAuthor(tx, username, postID); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(content) == "" {
+			result, err := tx.Run(`
+                MATCH (p:Post {id: $postID})
+                RETURN size([(p)-[:HAS_MEDIA]->(:Media) | 1]) + CASE WHEN p.ImageURL IS NULL OR p.ImageURL = '' THEN 0 ELSE 1 END AS media`,
+				map[string]interface{}{"postID": postID})
+			if err != nil {
+				return nil, err
+			}
+			if err := expectUpdated(result, "media", ErrEmptyPost); err != nil {
+				return nil, err
 			}
 		}
 
-		if imageURLValue, ok := record.Get("imageURL"); ok && imageURLValue != nil {
-			imageURL = imageURLValue.(string)
+		result, err := tx.Run(`
+            MATCH (author:User)-[:POSTED]->(p:Post {id: $postID})
+            WITH author, p, timestamp() AS now
+            CREATE (rev:PostRevision {
+                id: $revisionID,
+                content: p.content,
+                createdAt: coalesce(p.updatedAt, p.createdAt),
+                replacedAt: now,
+                version: size([(p)-[:PREVIOUS_VERSION]->(:PostRevision) | 1]) + 1
+            })
+            CREATE (p)-[:PREVIOUS_VERSION]->(rev)
+            SET p.content = $content, p.updatedAt = now
+            RETURN `+postFields,
+			map[string]interface{}{
+				"postID":     postID,
+				"viewer":     username,
+				"revisionID": uuid.New().String(),
+				"content":    content,
+			})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		post := postFromRecord(record)
+		return &post, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.Post), nil
+}
+
+// SetPostVisibility cambia quien puede ver el post. visibleTo solo se usa con
+// la visibilidad custom.
+func (r *postsRepository) SetPostVisibility(username, postID, visibility string, visibleTo []string) (*data.Post, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostAuthor(tx, username, postID); err != nil {
+			return nil, err
+		}
+		if err := setVisibleTo(tx, postID, visibleTo); err != nil {
+			return nil, err
 		}
 
-		post := data.Post{
-			ID:       id,
-			Content:  content,
-			Likes:    int(likes),
-			Comments: commentsSlice,
-			ImageURL: imageURL,
+		result, err := tx.Run(`
+            MATCH (author:User)-[:POSTED]->(p:Post {id: $postID})
+            SET p.visibility = $visibility
+            RETURN `+postFields,
+			map[string]interface{}{
+				"postID":     postID,
+				"viewer":     username,
+				"visibility": visibility,
+			})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
 		}
-		posts = append(posts, post)
+		post := postFromRecord(record)
+		return &post, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err = result.Err(); err != nil {
+	return result.(*data.Post), nil
+}
+
+// GetPostRevisions devuelve las versiones anteriores de un post, de la mas
+// reciente a la mas antigua. Solo las puede ver el autor del post.
+func (r *postsRepository) GetPostRevisions(username, postID string) ([]data.PostRevision, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostAuthor(tx, username, postID); err != nil {
+			return nil, err
+		}
+
+		result, err := tx.Run(`
+            MATCH (p:Post {id: $postID})-[:PREVIOUS_VERSION]->(rev:PostRevision)
+            RETURN rev.id AS id, p.id AS postID, rev.version AS version, rev.content AS content,
+                   rev.createdAt AS createdAt, rev.replacedAt AS replacedAt
+            ORDER BY rev.version DESC`,
+			map[string]interface{}{"postID": postID})
+		if err != nil {
+			return nil, err
+		}
+
+		revisions := []data.PostRevision{}
+		for result.Next() {
+			record := result.Record()
+			revisions = append(revisions, data.PostRevision{
+				ID:         recordString(record, "id"),
+				PostID:     recordString(record, "postID"),
+				Version:    int(recordInt(record, "version")),
+				Content:    recordString(record, "content"),
+				CreatedAt:  recordTime(record, "createdAt"),
+				ReplacedAt: recordTime(record, "replacedAt"),
+			})
+		}
+		if err = result.Err(); err != nil {
+			return nil, err
+		}
+		return revisions, nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	return result.([]data.PostRevision), nil
+}
 
-	return posts, nil
+// checkPostAuthor comprueba que el post existe y que lo publico username.
+func checkPostAuthor(tx neo4j.Transaction, username, postID string) error {
+	result, err := tx.Run(`
+        MATCH (p:Post {id: $postID})
+        OPTIONAL MATCH (owner:User)-[:POSTED]->(p)
+        RETURN owner.username AS owner`,
+		map[string]interface{}{"postID": postID})
+	if err != nil {
+		return err
+	}
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return err
+		}
+		return ErrPostNotFound
+	}
+	if recordString(result.Record(), "owner") != username {
+		return ErrNotPostAuthor
+	}
+	return nil
+}
+
+// CheckPostVisible devuelve ErrPostNotFound si el post no existe o viewer no
+// lo puede ver.
+func (r *postsRepository) CheckPostVisible(viewer, postID string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	_, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		return nil, checkPostVisible(tx, viewer, postID)
+	})
+	return err
 }
 
-func (r *postsRepository) DeletePost(username, postID string) error {
+// checkPostVisible comprueba que el post existe y que viewer lo puede ver. Los
+// posts que no puede ver se tratan como si no existieran.
+func checkPostVisible(tx neo4j.Transaction, viewer, postID string) error {
+	result, err := tx.Run(`
+        MATCH (author:User)-[:POSTED]->(p:Post {id: $postID})
+        WHERE `+visiblePostCondition+`
+        RETURN count(p) AS visible`,
+		map[string]interface{}{"postID": postID, "viewer": viewer})
+	if err != nil {
+		return err
+	}
+	return expectUpdated(result, "visible", ErrPostNotFound)
+}
+
+// deletePostsQuery borra cada post p con sus comentarios, versiones y
+// adjuntos, y devuelve por cada uno las keys de los adjuntos y sus versiones
+// reducidas.
+const deletePostsQuery = `
+	OPTIONAL MATCH (c:Comment)-[:ON]->(p)
+	WITH p, collect(c) AS comments
+	OPTIONAL MATCH (p)-[:PREVIOUS_VERSION]->(rev:PostRevision)
+	WITH p, comments, collect(rev) AS revisions
+	OPTIONAL MATCH (p)-[:HAS_MEDIA]->(m:Media)
+	OPTIONAL MATCH (m)-[:HAS_RENDITION]->(rd:Rendition)
+	WITH p, comments, revisions, collect(DISTINCT m) AS media, collect(rd) AS renditions
+	WITH p, comments + revisions + media + renditions AS nodes,
+	     [n IN media + renditions | n.key] AS keys
+	FOREACH (n IN nodes | DETACH DELETE n)
+	DETACH DELETE p
+	RETURN keys`
+
+// DeletePost borra el post con sus comentarios, versiones y adjuntos, y
+// devuelve las keys de los adjuntos y sus versiones reducidas para
+// borrarlos del storage. Devuelve ErrPostNotFound si el post no existe y
+// ErrNotPostAuthor si no es de username.
+func (r *postsRepository) DeletePost(username, postID string) ([]string, error) {
 	session := r.driver.NewSession(neo4j.SessionConfig{})
 	defer session.Close()
 
-	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		result, err := tx.Run(`
-            MATCH (u:User {username: $username})-[:POSTED]->(p:Post {id: $postID})
-            DETACH DELETE p
-        `, map[string]interface{}{
-			"username": username,
-			"postID":   postID,
-		})
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostAuthor(tx, username, postID); err != nil {
+			return nil, err
+		}
+		result, err := tx.Run(
+			`MATCH (u:User {username: $username})-[:POSTED]->(p:Post {id: $postID})`+deletePostsQuery,
+			map[string]interface{}{
+				"username": username,
+				"postID":   postID,
+			},
+		)
 		if err != nil {
 			log.Printf("Error running Cypher query: %v", err)
 			return nil, err
 		}
 
-		log.Printf("Delete query result: %v", result)
-
-		return nil, nil
+		var keys []string
+		if result.Next() {
+			keys = recordStrings(result.Record(), "keys")
+		}
+		return keys, result.Err()
 	})
 	if err != nil {
-		log.Printf("Error in write transaction: %v", err)
+		return nil, err
 	}
 
-	return err
+	return result.([]string), nil
 }
 
+// LikePost es la reaccion de tipo like. Es idempotente: dar like dos veces
+// deja una sola reaccion.
 func (s *postsRepository) LikePost(username, postID string) error {
+	return s.SetReaction(username, postID, data.ReactionLike)
+}
+
+// UnlikePost quita el like de username. Quitar un like que no existe no es
+// un error.
+func (s *postsRepository) UnlikePost(username, postID string) error {
+	return s.RemoveReaction(username, postID, data.ReactionLike)
+}
+
+func (s *postsRepository) GetLikesFromPost(viewer, postId string) ([]string, error) {
+	reactions, err := s.GetReactions(viewer, postId, data.ReactionLike)
+	if err != nil {
+		return nil, err
+	}
+
+	var likes []string
+	for _, reaction := range reactions {
+		likes = append(likes, reaction.Username)
+	}
+	return likes, nil
+}
+
+// SetReaction guarda la reaccion de username al post, reemplazando la que
+// tuviera antes. Solo se puede reaccionar a los posts visibles.
+func (s *postsRepository) SetReaction(username, postID, reactionType string) error {
 	session := s.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close()
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
-		_, err := transaction.Run(
+		if err := checkPostVisible(transaction, username, postID); err != nil {
+			return nil, err
+		}
+		result, err := transaction.Run(
 			`MATCH (p:Post {id: $postID})
       MATCH (u:User {username: $username})
-      MERGE (u)-[r:LIKED]->(p)
+      MERGE (u)-[r:REACTED]->(p)
       ON CREATE SET r.timestamp = timestamp()
-      SET p.likes = p.likes + 1`,
+      SET r.timestamp = CASE WHEN r.type = $type THEN r.timestamp ELSE timestamp() END,
+          r.type = $type
+      RETURN count(r) AS reacted`,
 			map[string]interface{}{
 				"postID":   postID,
 				"username": username,
+				"type":     reactionType,
 			})
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "reacted", ErrPostNotFound)
 	})
 	return err
 }
 
-func (s *postsRepository) GetLikesFromPost(postId string) ([]string, error) {
+// RemoveReaction quita la reaccion de username al post. Si reactionType no
+// esta vacio solo se quita cuando la reaccion es de ese tipo.
+func (s *postsRepository) RemoveReaction(username, postID, reactionType string) error {
+	session := s.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (p:Post {id: $postID})
+      OPTIONAL MATCH (:User {username: $username})-[r:REACTED]->(p)
+      WHERE $type = '' OR r.type = $type
+      DELETE r
+      RETURN count(p) AS found`,
+			map[string]interface{}{
+				"postID":   postID,
+				"username": username,
+				"type":     reactionType,
+			})
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "found", ErrPostNotFound)
+	})
+	return err
+}
+
+// GetReactions devuelve quien reacciono al post y con que, de la reaccion
+// mas reciente a la mas antigua, sin los usuarios bloqueados con viewer. Si
+// reactionType no esta vacio se filtra por ese tipo.
+func (s *postsRepository) GetReactions(viewer, postID, reactionType string) ([]data.Reaction, error) {
 	session := s.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
 	defer session.Close()
 
 	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostVisible(tx, viewer, postID); err != nil {
+			return nil, err
+		}
 		result, err := tx.Run(
-			`MATCH (p:Post {id: $postId})<-[:LIKED]-(u:User)
-             RETURN u.username AS username`,
+			`MATCH (p:Post {id: $postID})<-[r:REACTED]-(reactor:User)
+             WHERE ($type = '' OR r.type = $type) AND `+reactorNotBlocked+`
+             RETURN reactor.username AS username, r.type AS type, r.timestamp AS createdAt
+             ORDER BY r.timestamp DESC`,
 			map[string]interface{}{
-				"postId": postId,
+				"postID": postID,
+				"viewer": viewer,
+				"type":   reactionType,
 			},
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		var likes []string
+		reactions := []data.Reaction{}
 		for result.Next() {
 			record := result.Record()
-			if username, ok := record.Get("username"); ok {
-				if username, ok := username.(string); ok {
-					likes = append(likes, username)
-				}
+			reactions = append(reactions, data.Reaction{
+				Username:  recordString(record, "username"),
+				Type:      recordString(record, "type"),
+				CreatedAt: recordTime(record, "createdAt"),
+			})
+		}
+		if err = result.Err(); err != nil {
+			return nil, err
+		}
+		return reactions, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]data.Reaction), nil
+}
+
+// MigrateLegacyLikes convierte las relaciones LIKED anteriores a las
+// reacciones en REACTED {type: 'like'}, por lotes y solo hasta completarla.
+func (s *postsRepository) MigrateLegacyLikes() error {
+	return runMigration(s.driver, "legacy_likes", `
+        MATCH (u:User)-[l:LIKED]->(p:Post)
+        WITH u, l, p LIMIT $batchSize
+        MERGE (u)-[r:REACTED]->(p)
+        ON CREATE SET r.type = $type, r.timestamp = coalesce(l.timestamp, timestamp())
+        DELETE l
+        RETURN count(l) AS migrated`,
+		map[string]interface{}{"type": data.ReactionLike})
+}
+
+// MigrateLegacyComments convierte los comentarios guardados en la lista
+// comments del nodo Post en nodos Comment. No se sabe quien los escribio, asi
+// que quedan sin autor y con legacy: true; se ordenan como estaban en la
+// lista.
+func (r *postsRepository) MigrateLegacyComments() error {
+	return runMigration(r.driver, "legacy_comments", `
+        MATCH (p:Post) WHERE p.comments IS NOT NULL
+        WITH p LIMIT $batchSize
+        FOREACH (i IN range(0, size(p.comments) - 1) |
+            CREATE (:Comment {
+                id: randomUUID(), content: p.comments[i], legacy: true,
+                createdAt: coalesce(p.createdAt, 0) + i, updatedAt: coalesce(p.createdAt, 0) + i
+            })-[:ON]->(p))
+        REMOVE p.comments
+        RETURN count(p) AS migrated`, nil)
+}
+
+// MigrateLegacyPostTimestamps pone createdAt a 0 en los posts creados antes
+// de que se guardara. Es el mismo valor que les dan coalesce(p.createdAt, 0)
+// y el cursor del feed, asi que el orden no cambia.
+func (r *postsRepository) MigrateLegacyPostTimestamps() error {
+	return runMigration(r.driver, "legacy_post_timestamps", `
+        MATCH (p:Post) WHERE p.createdAt IS NULL
+        WITH p LIMIT $batchSize
+        SET p.createdAt = 0
+        RETURN count(p) AS migrated`, nil)
+}
+
+const commentFields = `
+	c.id AS id, p.id AS postID, parent.id AS parentID, author.username AS author,
+	c.content AS content, c.createdAt AS createdAt, c.updatedAt AS updatedAt,
+	size([(c)<-[:REPLY_TO]-(comment:Comment) WHERE ` + visibleCommentWriter + ` | 1]) AS replyCount`
+
+func commentFromRecord(record *neo4j.Record) data.Comment {
+	return data.Comment{
+		ID:         recordString(record, "id"),
+		PostID:     recordString(record, "postID"),
+		ParentID:   recordString(record, "parentID"),
+		Author:     recordString(record, "author"),
+		Content:    recordString(record, "content"),
+		ReplyCount: int(recordInt(record, "replyCount")),
+		CreatedAt:  recordTime(record, "createdAt"),
+		UpdatedAt:  recordTime(record, "updatedAt"),
+	}
+}
+
+// CreateComment crea (:User)-[:WROTE]->(:Comment)-[:ON]->(:Post). Si el
+// comentario tiene ParentID se enlaza ademas con [:REPLY_TO] al comentario
+// padre, que debe pertenecer al mismo post.
+func (r *postsRepository) CreateComment(username string, comment data.Comment) (*data.Comment, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostVisible(tx, username, comment.PostID); err != nil {
+			return nil, err
+		}
+		if comment.ParentID != "" {
+			if err := checkCommentVisible(tx, username, comment.PostID, comment.ParentID); err != nil {
+				return nil, err
 			}
 		}
+		result, err := tx.Run(`
+            MATCH (author:User {username: $username})
+            MATCH (p:Post {id: $postID})
+            OPTIONAL MATCH (parent:Comment {id: $parentID})-[:ON]->(p)
+            WITH author, p, parent
+            WHERE $parentID = '' OR parent IS NOT NULL
+            CREATE (c:Comment {id: $id, content: $content, createdAt: timestamp(), updatedAt: timestamp()})
+            CREATE (author)-[:WROTE]->(c)
+            CREATE (c)-[:ON]->(p)
+            FOREACH (_ IN CASE WHEN parent IS NULL THEN [] ELSE [1] END | CREATE (c)-[:REPLY_TO]->(parent))
+            RETURN `+commentFields,
+			map[string]interface{}{
+				"username": username,
+				"viewer":   username,
+				"postID":   comment.PostID,
+				"parentID": comment.ParentID,
+				"id":       comment.ID,
+				"content":  comment.Content,
+			})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			if comment.ParentID != "" {
+				return nil, ErrCommentNotFound
+			}
+			return nil, ErrPostNotFound
+		}
+		created := commentFromRecord(result.Record())
+		return &created, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.Comment), nil
+}
 
+// GetComments devuelve los comentarios de primer nivel de un post, o las
+// respuestas a parentID si no esta vacio, del mas antiguo al mas nuevo.
+// No incluye los comentarios de usuarios bloqueados por viewer o que le han
+// bloqueado, ni los de cuentas con el borrado programado. Devuelve
+// ErrPostNotFound si viewer no puede ver el post y ErrCommentNotFound si no
+// puede ver el comentario parentID.
+func (r *postsRepository) GetComments(viewer, postID, parentID string, skip, limit int) ([]data.Comment, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkPostVisible(tx, viewer, postID); err != nil {
+			return nil, err
+		}
+		if parentID != "" {
+			if err := checkCommentVisible(tx, viewer, postID, parentID); err != nil {
+				return nil, err
+			}
+		}
+		result, err := tx.Run(`
+            MATCH (c:Comment)-[:ON]->(p:Post {id: $postID})
+            OPTIONAL MATCH (author:User)-[:WROTE]->(c)
+            OPTIONAL MATCH (c)-[:REPLY_TO]->(parent:Comment)
+            WITH author, c, p, parent
+            WHERE (($parentID = '' AND parent IS NULL) OR parent.id = $parentID)
+              AND `+visibleCommentCondition+`
+            RETURN `+commentFields+`
+            ORDER BY c.createdAt ASC, c.id ASC
+            SKIP $skip LIMIT $limit`,
+			map[string]interface{}{
+				"postID":   postID,
+				"viewer":   viewer,
+				"parentID": parentID,
+				"skip":     skip,
+				"limit":    limit,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		comments := []data.Comment{}
+		for result.Next() {
+			comments = append(comments, commentFromRecord(result.Record()))
+		}
 		if err = result.Err(); err != nil {
 			return nil, err
 		}
+		return comments, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]data.Comment), nil
+}
+
+// checkCommentVisible devuelve ErrCommentNotFound si commentID no es un
+// comentario de postID o viewer no puede verlo.
+func checkCommentVisible(tx neo4j.Transaction, viewer, postID, commentID string) error {
+	result, err := tx.Run(`
+        MATCH (c:Comment {id: $commentID})-[:ON]->(:Post {id: $postID})
+        OPTIONAL MATCH (author:User)-[:WROTE]->(c)
+        WITH c, author
+        WHERE `+visibleCommentCondition+`
+        RETURN count(c) AS visible`,
+		map[string]interface{}{"commentID": commentID, "postID": postID, "viewer": viewer})
+	if err != nil {
+		return err
+	}
+	return expectUpdated(result, "visible", ErrCommentNotFound)
+}
+
+func (r *postsRepository) UpdateComment(username, commentID, content string) (*data.Comment, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkCommentAuthor(tx, username, commentID, false); err != nil {
+			return nil, err
+		}
 
-		return likes, nil
+		result, err := tx.Run(`
+            MATCH (author:User)-[:WROTE]->(c:Comment {id: $commentID})-[:ON]->(p:Post)
+            OPTIONAL MATCH (c)-[:REPLY_TO]->(parent:Comment)
+            SET c.content = $content, c.updatedAt = timestamp()
+            RETURN `+commentFields,
+			map[string]interface{}{
+				"commentID": commentID,
+				"viewer":    username,
+				"content":   content,
+			})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		updated := commentFromRecord(record)
+		return &updated, nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	return result.(*data.Comment), nil
+}
+
+// DeleteComment borra un comentario junto con todas sus respuestas. Lo puede
+// borrar su autor o el autor del post.
+func (r *postsRepository) DeleteComment(username, commentID string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
 
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := checkCommentAuthor(tx, username, commentID, true); err != nil {
+			return nil, err
+		}
+
+		_, err := tx.Run(deleteCommentQuery, map[string]interface{}{"commentID": commentID})
+		return nil, err
+	})
+	return err
+}
+
+// deleteCommentQuery borra el comentario $commentID con todas sus respuestas
+// y devuelve cuantos comentarios se borraron.
+const deleteCommentQuery = `
+	MATCH (c:Comment {id: $commentID})
+	OPTIONAL MATCH (reply:Comment)-[:REPLY_TO*]->(c)
+	WITH c, collect(reply) AS replies
+	FOREACH (reply IN replies | DETACH DELETE reply)
+	DETACH DELETE c
+	RETURN count(c) AS deleted`
+
+// ModeratePost borra un post de cualquier usuario, igual que DeletePost.
+func (r *postsRepository) ModeratePost(postID string) ([]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(`MATCH (p:Post {id: $postID})`+deletePostsQuery,
+			map[string]interface{}{"postID": postID})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrPostNotFound
+		}
+		return recordStrings(result.Record(), "keys"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return result.([]string), nil
 }
+
+// ModerateComment borra un comentario de cualquier usuario con todas sus
+// respuestas.
+func (r *postsRepository) ModerateComment(commentID string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(deleteCommentQuery, map[string]interface{}{"commentID": commentID})
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "deleted", ErrCommentNotFound)
+	})
+	return err
+}
+
+// checkCommentAuthor comprueba que el comentario existe y que username es su
+// autor (o el autor del post si allowPostOwner es true).
+func checkCommentAuthor(tx neo4j.Transaction, username, commentID string, allowPostOwner bool) error {
+	result, err := tx.Run(`
+        MATCH (c:Comment {id: $commentID})-[:ON]->(p:Post)
+        OPTIONAL MATCH (author:User)-[:WROTE]->(c)
+        OPTIONAL MATCH (owner:User)-[:POSTED]->(p)
+        RETURN author.username AS author, owner.username AS owner`,
+		map[string]interface{}{"commentID": commentID})
+	if err != nil {
+		return err
+	}
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return err
+		}
+		return ErrCommentNotFound
+	}
+
+	record := result.Record()
+	if recordString(record, "author") == username {
+		return nil
+	}
+	if allowPostOwner && recordString(record, "owner") == username {
+		return nil
+	}
+	return ErrNotCommentAuthor
+}
@@ -0,0 +1,90 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// Cada tipo de data.ReactionTypes se cuenta por separado y cambiar de
+// reaccion mueve el voto de un tipo a otro.
+func TestReactionCountsPerType(t *testing.T) {
+	driver, prefix := newTestDriver(t)
+	users := NewUserRepository(driver)
+	posts := NewPostsRepository(driver)
+
+	author := prefix + "author"
+	if err := users.CreateUser(author, "hash", author+"@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	postID := prefix + "-post"
+	post := data.Post{ID: postID, Content: "hola", Visibility: data.VisibilityPublic, CreatedAt: time.Now()}
+	if err := posts.CreatePost(author, post); err != nil {
+		t.Fatalf("CreatePost: %v", err)
+	}
+
+	// El usuario i reacciona con el tipo i y despues reactor0 cambia a
+	// love: queda un tipo sin reacciones y otro con dos.
+	want := map[string]int{}
+	for i, reactionType := range data.ReactionTypes {
+		username := fmt.Sprintf("%sreactor%d", prefix, i)
+		if err := users.CreateUser(username, "hash", username+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := posts.SetReaction(username, postID, reactionType); err != nil {
+			t.Fatalf("SetReaction: %v", err)
+		}
+		want[reactionType]++
+	}
+	first := prefix + "reactor0"
+	if err := posts.SetReaction(first, postID, data.ReactionLove); err != nil {
+		t.Fatalf("SetReaction: %v", err)
+	}
+	want[data.ReactionTypes[0]]--
+	want[data.ReactionLove]++
+
+	got, err := posts.GetUserPost(first, author)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("GetUserPost = %v, %v", got, err)
+	}
+	for _, reactionType := range data.ReactionTypes {
+		if got[0].Reactions[reactionType] != want[reactionType] {
+			t.Errorf("Reactions[%s] = %d, se esperaba %d", reactionType, got[0].Reactions[reactionType], want[reactionType])
+		}
+	}
+	if got[0].Likes != want[data.ReactionLike] || got[0].LikedByMe || got[0].MyReaction != data.ReactionLove {
+		t.Errorf("Likes = %d, LikedByMe = %v, MyReaction = %q", got[0].Likes, got[0].LikedByMe, got[0].MyReaction)
+	}
+}
+
+func TestPostFromRecordReactionCounts(t *testing.T) {
+	record := &neo4j.Record{
+		Keys: []string{"id", "reactionCounts", "myReaction"},
+		Values: []interface{}{
+			"p1",
+			[]interface{}{
+				[]interface{}{data.ReactionLike, int64(3)},
+				[]interface{}{data.ReactionLove, int64(0)},
+				[]interface{}{data.ReactionWow, int64(1)},
+			},
+			data.ReactionLike,
+		},
+	}
+
+	post := postFromRecord(record)
+	if post.Likes != 3 || !post.LikedByMe {
+		t.Errorf("Likes = %d, LikedByMe = %v; se esperaba 3, true", post.Likes, post.LikedByMe)
+	}
+	want := map[string]int{data.ReactionLike: 3, data.ReactionWow: 1}
+	if len(post.Reactions) != len(want) {
+		t.Fatalf("Reactions = %v, se esperaba %v", post.Reactions, want)
+	}
+	for reactionType, count := range want {
+		if post.Reactions[reactionType] != count {
+			t.Errorf("Reactions[%s] = %d, se esperaba %d", reactionType, post.Reactions[reactionType], count)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package Repositories
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func recordString(record *neo4j.Record, key string) string {
+	if value, ok := record.Get(key); ok && value != nil {
+		if s, ok := value.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func recordInt(record *neo4j.Record, key string) int64 {
+	if value, ok := record.Get(key); ok && value != nil {
+		if i, ok := value.(int64); ok {
+			return i
+		}
+	}
+	return 0
+}
+
+func recordBool(record *neo4j.Record, key string) bool {
+	if value, ok := record.Get(key); ok && value != nil {
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
+
+// recordStrings devuelve la lista de strings de key, ignorando los valores
+// nulos o vacios.
+func recordStrings(record *neo4j.Record, key string) []string {
+	var strings []string
+	if value, ok := record.Get(key); ok && value != nil {
+		if values, ok := value.([]interface{}); ok {
+			for _, v := range values {
+				if s, ok := v.(string); ok && s != "" {
+					strings = append(strings, s)
+				}
+			}
+		}
+	}
+	return strings
+}
+
+// recordTime convierte un timestamp en milisegundos (como el que devuelve
+// timestamp() en Cypher) a time.Time.
+func recordTime(record *neo4j.Record, key string) time.Time {
+	millis := recordInt(record, key)
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// expectUpdated lee una consulta que devuelve un unico contador en key y
+// devuelve notFound si no hay filas o el contador es cero.
+func expectUpdated(result neo4j.Result, key string, notFound error) error {
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return err
+		}
+		return notFound
+	}
+	if recordInt(result.Record(), key) == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// isConstraintViolation indica si err es la violacion de una restriccion de
+// unicidad sobre property en los nodos label. Neo4j no incluye el nombre de
+// la restriccion en el error, pero si el label y las propiedades.
+func isConstraintViolation(err error, label, property string) bool {
+	var neo4jError *neo4j.Neo4jError
+	return errors.As(err, &neo4jError) &&
+		neo4jError.Code == "Neo.ClientError.Schema.ConstraintValidationFailed" &&
+		strings.Contains(neo4jError.Msg, "`"+label+"`") &&
+		strings.Contains(neo4jError.Msg, "`"+property+"`")
+}
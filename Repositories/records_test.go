@@ -0,0 +1,33 @@
+package Repositories
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func TestIsConstraintViolation(t *testing.T) {
+	const code = "Neo.ClientError.Schema.ConstraintValidationFailed"
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"username", &neo4j.Neo4jError{Code: code, Msg: "Node(0) already exists with label `User` and property `username` = 'alice'"}, true},
+		{"envuelto", fmt.Errorf("crear usuario: %w", &neo4j.Neo4jError{Code: code, Msg: "Node(0) already exists with label `User` and property `username` = 'alice'"}), true},
+		{"id", &neo4j.Neo4jError{Code: code, Msg: "Node(0) already exists with label `User` and property `id` = 'x'"}, false},
+		{"otro label", &neo4j.Neo4jError{Code: code, Msg: "Node(0) already exists with label `Identity` and properties `provider` = 'google', `subject` = 'username'"}, false},
+		{"otro codigo", &neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError", Msg: "`User` `username`"}, false},
+		{"otro error", errors.New("`User` `username`"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConstraintViolation(tt.err, "User", "username"); got != tt.want {
+				t.Errorf("isConstraintViolation = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
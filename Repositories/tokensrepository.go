@@ -0,0 +1,281 @@
+package Repositories
+
+import (
+	"sync"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// TokenRepository guarda los identificadores (jti) de los tokens revocados
+// hasta que expiran, y desde cuando son validas las sesiones de cada usuario
+// para poder revocarlas todas de una vez.
+type TokenRepository interface {
+	// RevokeToken revoca el token e indica si lo ha revocado esta llamada o
+	// ya estaba revocado. Para tokens de un solo uso, como el refresh token,
+	// solo debe aceptarse la llamada que lo revoca.
+	RevokeToken(tokenID string, expiresAt time.Time) (bool, error)
+	IsTokenRevoked(tokenID string) (bool, error)
+	DeleteExpiredTokens(now time.Time) error
+	RevokeUserTokens(username string, issuedBefore time.Time) error
+	IsSessionRevoked(username string, issuedAt time.Time) (bool, error)
+	MigrateSessionCutoffs() error
+}
+
+const (
+	// sessionCutoffTTL es cuanto se guarda en memoria el corte de sesiones de
+	// un usuario. Las revocaciones hechas en otra instancia tardan como mucho
+	// esto en aplicarse; las de esta instancia se aplican al momento.
+	sessionCutoffTTL = 30 * time.Second
+	// maxCachedCutoffs limita la cache; al llenarse se vacia entera.
+	maxCachedCutoffs = 10000
+)
+
+// sessionCutoff es el corte de sesiones de un usuario en milisegundos.
+// exists es false si el usuario no existe.
+type sessionCutoff struct {
+	validAfter int64
+	exists     bool
+	fetchedAt  time.Time
+}
+
+type tokenRepository struct {
+	driver neo4j.Driver
+
+	mu      sync.Mutex
+	cutoffs map[string]sessionCutoff
+}
+
+func NewTokenRepository(driver neo4j.Driver) TokenRepository {
+	return &tokenRepository{driver: driver, cutoffs: make(map[string]sessionCutoff)}
+}
+
+// RevokeToken depende de la restriccion de unicidad de RevokedToken.jti (ver
+// db.EnsureSchema): con ella dos MERGE simultaneos del mismo jti se
+// serializan y solo uno crea el nodo.
+func (r *tokenRepository) RevokeToken(tokenID string, expiresAt time.Time) (bool, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	result, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MERGE (t:RevokedToken {jti: $jti})
+             ON CREATE SET t.expiresAt = $expiresAt, t.created = true
+             WITH t, coalesce(t.created, false) AS revoked
+             REMOVE t.created
+             RETURN revoked`,
+			map[string]interface{}{
+				"jti":       tokenID,
+				"expiresAt": expiresAt.Unix(),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		return recordBool(record, "revoked"), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.(bool), nil
+}
+
+// DeleteExpiredTokens borra los tokens revocados que ya han caducado, que no
+// hace falta seguir guardando.
+func (r *tokenRepository) DeleteExpiredTokens(now time.Time) error {
+	return deleteInBatches(r.driver,
+		`MATCH (t:RevokedToken) WHERE t.expiresAt < $now
+		 WITH t LIMIT $batchSize
+		 DELETE t
+		 RETURN count(*) AS deleted`,
+		map[string]interface{}{"now": now.Unix()})
+}
+
+func (r *tokenRepository) IsTokenRevoked(tokenID string) (bool, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (t:RevokedToken {jti: $jti}) RETURN count(t) > 0 AS revoked`,
+			map[string]interface{}{"jti": tokenID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		revoked, _ := record.Get("revoked")
+		return revoked, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.(bool), nil
+}
+
+// RevokeUserTokens invalida todos los tokens de username emitidos antes de
+// issuedBefore. Se guarda en el propio nodo User, en milisegundos.
+func (r *tokenRepository) RevokeUserTokens(username string, issuedBefore time.Time) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		_, err := transaction.Run(
+			`MATCH (u:User {username: $username}) SET u.tokensValidAfter = $issuedBefore`,
+			map[string]interface{}{
+				"username":     username,
+				"issuedBefore": issuedBefore.UnixMilli(),
+			},
+		)
+		return nil, err
+	})
+
+	r.mu.Lock()
+	delete(r.cutoffs, username)
+	r.mu.Unlock()
+	return err
+}
+
+// IsSessionRevoked indica si un token de username emitido en issuedAt fue
+// revocado con RevokeUserTokens. Los tokens de usuarios que ya no existen
+// tambien se consideran revocados. El corte se cachea sessionCutoffTTL para
+// no consultar la base de datos en cada peticion.
+func (r *tokenRepository) IsSessionRevoked(username string, issuedAt time.Time) (bool, error) {
+	cutoff, err := r.sessionCutoff(username)
+	if err != nil {
+		return false, err
+	}
+	return !cutoff.exists || issuedAt.UnixMilli() < cutoff.validAfter, nil
+}
+
+func (r *tokenRepository) sessionCutoff(username string) (sessionCutoff, error) {
+	now := time.Now()
+	r.mu.Lock()
+	cutoff, ok := r.cutoffs[username]
+	r.mu.Unlock()
+	if ok && now.Sub(cutoff.fetchedAt) < sessionCutoffTTL {
+		return cutoff, nil
+	}
+
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`OPTIONAL MATCH (u:User {username: $username})
+			 RETURN u IS NOT NULL AS exists, coalesce(u.tokensValidAfter, 0) AS validAfter`,
+			map[string]interface{}{"username": username},
+		)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		return sessionCutoff{
+			validAfter: recordInt(record, "validAfter"),
+			exists:     recordBool(record, "exists"),
+			fetchedAt:  now,
+		}, nil
+	})
+	if err != nil {
+		return sessionCutoff{}, err
+	}
+	cutoff = result.(sessionCutoff)
+
+	r.mu.Lock()
+	if len(r.cutoffs) >= maxCachedCutoffs {
+		r.cutoffs = make(map[string]sessionCutoff)
+	}
+	r.cutoffs[username] = cutoff
+	r.mu.Unlock()
+	return cutoff, nil
+}
+
+// MigrateSessionCutoffs pasa a milisegundos los tokensValidAfter que se
+// guardaban en segundos. Cualquier valor por debajo de 1e11 es de segundos:
+// en milisegundos corresponderia a 1973.
+func (r *tokenRepository) MigrateSessionCutoffs() error {
+	return runMigration(r.driver, "session_cutoffs_millis", `
+        MATCH (u:User) WHERE u.tokensValidAfter < 100000000000
+        WITH u LIMIT $batchSize
+        SET u.tokensValidAfter = u.tokensValidAfter * 1000
+        RETURN count(u) AS migrated`, nil)
+}
+
+type inMemoryTokenRepository struct {
+	mu          sync.Mutex
+	revoked     map[string]time.Time
+	validAfters map[string]time.Time
+}
+
+// NewInMemoryTokenRepository devuelve un TokenRepository que no necesita
+// Neo4j, pensado para tests y desarrollo local.
+func NewInMemoryTokenRepository() TokenRepository {
+	return &inMemoryTokenRepository{
+		revoked:     make(map[string]time.Time),
+		validAfters: make(map[string]time.Time),
+	}
+}
+
+func (r *inMemoryTokenRepository) RevokeToken(tokenID string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.revoked[tokenID]; ok {
+		return false, nil
+	}
+	r.revoked[tokenID] = expiresAt
+	return true, nil
+}
+
+func (r *inMemoryTokenRepository) DeleteExpiredTokens(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, exp := range r.revoked {
+		if exp.Before(now) {
+			delete(r.revoked, id)
+		}
+	}
+	return nil
+}
+
+func (r *inMemoryTokenRepository) IsTokenRevoked(tokenID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.revoked[tokenID]
+	return ok, nil
+}
+
+func (r *inMemoryTokenRepository) RevokeUserTokens(username string, issuedBefore time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.validAfters[username] = issuedBefore
+	return nil
+}
+
+// IsSessionRevoked funciona como en Neo4j. Este repositorio no conoce los
+// usuarios, asi que solo existen los que tienen un corte de RevokeUserTokens
+// y los tokens del resto se consideran revocados.
+func (r *inMemoryTokenRepository) IsSessionRevoked(username string, issuedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	validAfter, ok := r.validAfters[username]
+	return !ok || issuedAt.UnixMilli() < validAfter.UnixMilli(), nil
+}
+
+func (r *inMemoryTokenRepository) MigrateSessionCutoffs() error {
+	return nil
+}
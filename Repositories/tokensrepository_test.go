@@ -0,0 +1,70 @@
+package Repositories
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInMemoryRevokeToken(t *testing.T) {
+	repo := NewInMemoryTokenRepository()
+	expiresAt := time.Now().Add(time.Hour)
+
+	revoked, err := repo.RevokeToken("jti-1", expiresAt)
+	if err != nil || !revoked {
+		t.Fatalf("primera revocacion = %v, %v; se esperaba true", revoked, err)
+	}
+	revoked, err = repo.RevokeToken("jti-1", expiresAt)
+	if err != nil || revoked {
+		t.Fatalf("segunda revocacion = %v, %v; se esperaba false", revoked, err)
+	}
+	if revoked, _ := repo.IsTokenRevoked("jti-1"); !revoked {
+		t.Fatal("jti-1 deberia estar revocado")
+	}
+}
+
+func TestInMemoryDeleteExpiredTokens(t *testing.T) {
+	repo := NewInMemoryTokenRepository()
+	now := time.Now()
+	repo.RevokeToken("expired", now.Add(-time.Minute))
+	repo.RevokeToken("valid", now.Add(time.Minute))
+
+	if err := repo.DeleteExpiredTokens(now); err != nil {
+		t.Fatalf("DeleteExpiredTokens: %v", err)
+	}
+	if revoked, _ := repo.IsTokenRevoked("expired"); revoked {
+		t.Error("el token caducado deberia haberse borrado")
+	}
+	if revoked, _ := repo.IsTokenRevoked("valid"); !revoked {
+		t.Error("el token sin caducar deberia seguir revocado")
+	}
+}
+
+func TestInMemoryIsSessionRevoked(t *testing.T) {
+	repo := NewInMemoryTokenRepository()
+	cutoff := time.UnixMilli(1_700_000_000_200)
+	repo.RevokeUserTokens("alice", cutoff)
+
+	tests := []struct {
+		name     string
+		username string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"emitido antes del corte", "alice", cutoff.Add(-time.Hour), true},
+		{"emitido despues del corte", "alice", cutoff.Add(time.Hour), false},
+		{"mismo segundo, antes del corte", "alice", cutoff.Add(-100 * time.Millisecond), true},
+		{"mismo segundo, despues del corte", "alice", cutoff.Add(300 * time.Millisecond), false},
+		{"usuario inexistente", "bob", cutoff.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.IsSessionRevoked(tt.username, tt.issuedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsSessionRevoked = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,40 @@
 package Repositories
 
 import (
-	"errors"
+	data "SocialMedia/Data"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 )
 
 type UserRepository interface {
 	CreateUser(username, password, email string) error
-	GetUser(username string) (map[string]interface{}, error)
+	GetUser(username string) (*data.User, error)
+	GetProfile(viewer, username string) (*data.Profile, error)
+	UpdateProfile(username string, update data.ProfileUpdate) (*data.Profile, error)
+	SetAvatar(username, avatarURL string, avatarKeys []string) ([]string, error)
+	UpdatePassword(username, passwordHash string) error
+	SetRoles(username string, roles []string) error
+	AddRole(username, role string) error
+	UpdatePrivacy(username string, settings data.PrivacySettings) error
+	SetPendingEmail(username, email string) error
+	ConfirmEmail(username, email string) error
+	VerifyEmail(username, email string) error
+	GetUsersByEmail(email string) ([]*data.User, error)
+	GetUserByIdentity(provider, subject string) (*data.User, error)
+	LinkIdentity(username, provider, subject string) error
+	CreateUserWithIdentity(username, password, email string, emailVerified bool, provider, subject string) error
+	SetPendingTOTP(username, secret string) error
+	EnableTOTP(username, secret string, counter int64, recoveryCodes []string) error
+	DisableTOTP(username string) error
+	UseTOTPCounter(username string, counter int64) error
+	UseRecoveryCode(username, codeHash string) error
+	ScheduleDeletion(username string, deleteAfter time.Time) error
+	CancelDeletion(username string) error
+	GetUsersToDelete(now time.Time) ([]string, error)
+	DeleteUser(username string) ([]string, error)
+	MigrateLegacyUserIDs() error
 }
 
 type userRepository struct {
@@ -19,50 +45,648 @@ func NewUserRepository(driver neo4j.Driver) UserRepository {
 	return &userRepository{driver}
 }
 
+// MigrateLegacyUserIDs da un id a los usuarios creados antes de que se
+// guardara, para que todos puedan iniciar sesion con el claim uid.
+func (r *userRepository) MigrateLegacyUserIDs() error {
+	return runMigration(r.driver, "legacy_user_ids", `
+        MATCH (u:User) WHERE u.id IS NULL
+        WITH u LIMIT $batchSize
+        SET u.id = randomUUID()
+        RETURN count(u) AS migrated`, nil)
+}
+
 func (r *userRepository) CreateUser(username, password, email string) error {
 	session := r.driver.NewSession(neo4j.SessionConfig{})
 	defer session.Close()
 
 	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
 		result, err := transaction.Run(
-			"CREATE (u:User {username: $username, password: $password, email: $email})",
-			map[string]interface{}{"username": username, "password": password, "email": email},
+			"CREATE (u:User {id: $id, username: $username, password: $password, email: $email, emailVerified: false, roles: ['user'], joinedAt: timestamp()})",
+			map[string]interface{}{"id": uuid.New().String(), "username": username, "password": password, "email": email},
 		)
 		if err != nil {
-			if neo4jError, ok := err.(*neo4j.Neo4jError); ok && neo4jError.Code == "Neo.ClientError.Schema.ConstraintValidationFailed" {
-				return nil, errors.New("el username ya está en uso")
-			}
 			return nil, err
 		}
 		return result.Consume()
 	})
+	if isConstraintViolation(err, "User", "username") {
+		return ErrUsernameTaken
+	}
 	return err
 }
 
-func (r *userRepository) GetUser(username string) (map[string]interface{}, error) {
+// GetUser devuelve el usuario con el hash de su contrasena, o nil si no
+// existe. No se debe serializar en respuestas: usar GetProfile.
+func (r *userRepository) GetUser(username string) (*data.User, error) {
 	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
 	defer session.Close()
 	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
 		result, err := transaction.Run(
-			"MATCH (u:User {username: $username}) RETURN u",
+			"MATCH (u:User {username: $username}) RETURN "+userFields,
 			map[string]interface{}{"username": username},
 		)
 		if err != nil {
 			return nil, err
 		}
 		if result.Next() {
-			record := result.Record()
-			if node, ok := record.Values[0].(neo4j.Node); ok {
-				return node.Props, nil
+			return userFromRecord(result.Record()), nil
+		}
+		return (*data.User)(nil), result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.User), nil
+}
+
+const userFields = `
+	u.id AS id, u.email AS email, u.emailVerified AS emailVerified, u.password AS password,
+	coalesce(u.roles, ['user']) AS roles,
+	u.pendingEmail AS pendingEmail, u.deleteAfter AS deleteAfter,
+	u.totpEnabled AS totpEnabled, u.totpSecret AS totpSecret,
+	u.totpPendingSecret AS totpPendingSecret, u.recoveryCodes AS recoveryCodes,
+	coalesce(u.friendRequestPolicy, 'everyone') AS friendRequestPolicy,` + profileFields
+
+func userFromRecord(record *neo4j.Record) *data.User {
+	profile := profileFromRecord(record)
+	return &data.User{
+		ID:            recordString(record, "id"),
+		Username:      profile.Username,
+		Email:         recordString(record, "email"),
+		EmailVerified: recordBool(record, "emailVerified"),
+		PasswordHash:  recordString(record, "password"),
+		Roles:         recordStrings(record, "roles"),
+		DisplayName:   profile.DisplayName,
+		Bio:           profile.Bio,
+		AvatarURL:     profile.AvatarURL,
+		Location:      profile.Location,
+		Website:       profile.Website,
+		JoinedAt:      profile.JoinedAt,
+		PendingEmail:  recordString(record, "pendingEmail"),
+		DeleteAfter:   recordTime(record, "deleteAfter"),
+
+		TOTPEnabled:       recordBool(record, "totpEnabled"),
+		TOTPSecret:        recordString(record, "totpSecret"),
+		TOTPPendingSecret: recordString(record, "totpPendingSecret"),
+		RecoveryCodes:     recordStrings(record, "recoveryCodes"),
+
+		Privacy: data.PrivacySettings{
+			PrivateProfile: profile.Private,
+			FriendRequests: recordString(record, "friendRequestPolicy"),
+		},
+	}
+}
+
+const profileFields = `
+	u.username AS username, u.displayName AS displayName, u.bio AS bio,
+	u.avatarURL AS avatarURL, u.location AS location, u.website AS website,
+	u.joinedAt AS joinedAt, coalesce(u.privateProfile, false) AS privateProfile`
+
+func profileFromRecord(record *neo4j.Record) *data.Profile {
+	return &data.Profile{
+		Username:    recordString(record, "username"),
+		DisplayName: recordString(record, "displayName"),
+		Bio:         recordString(record, "bio"),
+		AvatarURL:   recordString(record, "avatarURL"),
+		Location:    recordString(record, "location"),
+		Website:     recordString(record, "website"),
+		JoinedAt:    recordTime(record, "joinedAt"),
+		Private:     recordBool(record, "privateProfile"),
+	}
+}
+
+// GetProfile devuelve el perfil publico del usuario tal como lo ve viewer,
+// o nil si no existe o tiene el borrado programado. De un perfil privado
+// solo sus amigos ven todos los datos.
+func (r *userRepository) GetProfile(viewer, username string) (*data.Profile, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username}) WHERE u.deleteAfter IS NULL
+			 RETURN `+profileFields+`,
+			        u.username = $viewer OR NOT coalesce(u.privateProfile, false)
+			        OR size([(u)-[:FRIEND {status: $accepted}]-(:User {username: $viewer}) | 1]) > 0 AS visible`,
+			map[string]interface{}{"username": username, "viewer": viewer, "accepted": data.FriendRequestAccepted},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.Next() {
+			profile := profileFromRecord(result.Record())
+			if !recordBool(result.Record(), "visible") {
+				profile = profile.Limited()
 			}
+			return profile, nil
 		}
-		return nil, result.Err()
+		return (*data.Profile)(nil), result.Err()
 	})
 	if err != nil {
 		return nil, err
 	}
-	if result == nil {
+	return result.(*data.Profile), nil
+}
+
+func (r *userRepository) UpdateProfile(username string, update data.ProfileUpdate) (*data.Profile, error) {
+	props := map[string]interface{}{}
+	if update.DisplayName != nil {
+		props["displayName"] = *update.DisplayName
+	}
+	if update.Bio != nil {
+		props["bio"] = *update.Bio
+	}
+	if update.Location != nil {
+		props["location"] = *update.Location
+	}
+	if update.Website != nil {
+		props["website"] = *update.Website
+	}
+
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	result, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			"MATCH (u:User {username: $username}) SET u += $props RETURN "+profileFields,
+			map[string]interface{}{"username": username, "props": props},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.Next() {
+			return profileFromRecord(result.Record()), nil
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrUserNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.Profile), nil
+}
+
+// SetAvatar guarda la URL del nuevo avatar y las keys de sus archivos en el
+// storage, y devuelve las keys del avatar anterior para poder borrarlas.
+func (r *userRepository) SetAvatar(username, avatarURL string, avatarKeys []string) ([]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+	result, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})
+			 WITH u, coalesce(u.avatarKeys, []) AS oldKeys
+			 SET u.avatarURL = $avatarURL, u.avatarKeys = $avatarKeys
+			 RETURN oldKeys`,
+			map[string]interface{}{
+				"username":   username,
+				"avatarURL":  avatarURL,
+				"avatarKeys": avatarKeys,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrUserNotFound
+		}
+
+		return recordStrings(result.Record(), "oldKeys"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]string), nil
+}
+
+func (r *userRepository) UpdatePassword(username, passwordHash string) error {
+	return r.updateUser(
+		"MATCH (u:User {username: $username}) SET u.password = $password RETURN count(u) AS updated",
+		map[string]interface{}{"username": username, "password": passwordHash},
+		ErrUserNotFound,
+	)
+}
+
+// SetRoles reemplaza los roles del usuario. Devuelve ErrLastAdmin si le
+// quitaria el rol admin al ultimo admin. Antes de contar los admins se
+// bloquea el nodo (:RoleGuard), asi que dos cambios simultaneos se hacen uno
+// detras de otro y no pueden quitar a la vez a los dos ultimos.
+func (r *userRepository) SetRoles(username string, roles []string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MERGE (g:RoleGuard {name: 'roles'}) SET g.lockedAt = timestamp()`, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := result.Consume(); err != nil {
+			return nil, err
+		}
+
+		result, err = transaction.Run(
+			`MATCH (u:User {username: $username})
+			 OPTIONAL MATCH (admin:User) WHERE $admin IN coalesce(admin.roles, [])
+			 WITH u, count(admin) AS admins
+			 WITH u, $admin IN coalesce(u.roles, []) AND NOT $admin IN $roles AND admins <= 1 AS lastAdmin
+			 FOREACH (_ IN CASE WHEN lastAdmin THEN [] ELSE [1] END | SET u.roles = $roles)
+			 RETURN lastAdmin`,
+			map[string]interface{}{"username": username, "roles": roles, "admin": data.RoleAdmin},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrUserNotFound
+		}
+		if recordBool(result.Record(), "lastAdmin") {
+			return nil, ErrLastAdmin
+		}
 		return nil, nil
+	})
+	return err
+}
+
+// AddRole anade role a los roles del usuario si no lo tiene.
+func (r *userRepository) AddRole(username, role string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username})
+		 SET u.roles = CASE WHEN $role IN coalesce(u.roles, []) THEN u.roles
+		                    ELSE coalesce(u.roles, [$user]) + $role END
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "role": role, "user": data.RoleUser},
+		ErrUserNotFound,
+	)
+}
+
+func (r *userRepository) UpdatePrivacy(username string, settings data.PrivacySettings) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username})
+		 SET u.privateProfile = $privateProfile, u.friendRequestPolicy = $friendRequests
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{
+			"username":       username,
+			"privateProfile": settings.PrivateProfile,
+			"friendRequests": settings.FriendRequests,
+		},
+		ErrUserNotFound,
+	)
+}
+
+// SetPendingEmail guarda el nuevo email hasta que el usuario lo confirme con
+// ConfirmEmail. Un nuevo cambio reemplaza al anterior.
+func (r *userRepository) SetPendingEmail(username, email string) error {
+	return r.updateUser(
+		"MATCH (u:User {username: $username}) SET u.pendingEmail = $email RETURN count(u) AS updated",
+		map[string]interface{}{"username": username, "email": email},
+		ErrUserNotFound,
+	)
+}
+
+// ConfirmEmail cambia el email por el pendiente si sigue siendo email. El
+// nuevo email queda verificado.
+func (r *userRepository) ConfirmEmail(username, email string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE u.pendingEmail = $email
+		 SET u.email = u.pendingEmail, u.emailVerified = true
+		 REMOVE u.pendingEmail
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "email": email},
+		ErrNoPendingEmail,
+	)
+}
+
+// GetUserByIdentity devuelve el usuario enlazado a la identidad externa
+// (proveedor OIDC y su sub), o nil si no hay ninguno.
+func (r *userRepository) GetUserByIdentity(provider, subject string) (*data.User, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User)-[:HAS_IDENTITY]->(:Identity {provider: $provider, subject: $subject})
+			 RETURN `+userFields,
+			map[string]interface{}{"provider": provider, "subject": subject},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.Next() {
+			return userFromRecord(result.Record()), nil
+		}
+		return (*data.User)(nil), result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*data.User), nil
+}
+
+// LinkIdentity enlaza una identidad externa a un usuario existente.
+// Devuelve ErrIdentityLinked si ya esta enlazada a otro usuario.
+func (r *userRepository) LinkIdentity(username, provider, subject string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MATCH (u:User {username: $username})
+			 MERGE (i:Identity {provider: $provider, subject: $subject})
+			 ON CREATE SET i.createdAt = timestamp()
+			 WITH u, i, size([(other:User)-[:HAS_IDENTITY]->(i) WHERE other <> u | 1]) > 0 AS linked
+			 FOREACH (_ IN CASE WHEN linked THEN [] ELSE [1] END | MERGE (u)-[:HAS_IDENTITY]->(i))
+			 RETURN linked`,
+			map[string]interface{}{"username": username, "provider": provider, "subject": subject},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrUserNotFound
+		}
+		if recordBool(result.Record(), "linked") {
+			return nil, ErrIdentityLinked
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// CreateUserWithIdentity crea un usuario registrado con un proveedor externo
+// y su identidad en la misma transaccion. Devuelve ErrIdentityLinked si la
+// identidad ya pertenece a un usuario, por ejemplo porque otro login con la
+// misma identidad la ha creado a la vez.
+func (r *userRepository) CreateUserWithIdentity(username, password, email string, emailVerified bool, provider, subject string) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			`MERGE (i:Identity {provider: $provider, subject: $subject})
+			 ON CREATE SET i.createdAt = timestamp()
+			 WITH i WHERE size([(:User)-[:HAS_IDENTITY]->(i) | 1]) = 0
+			 CREATE (u:User {id: $id, username: $username, password: $password, email: $email,
+			                 emailVerified: $emailVerified, roles: ['user'], joinedAt: timestamp()})
+			 CREATE (u)-[:HAS_IDENTITY]->(i)
+			 RETURN count(u) AS created`,
+			map[string]interface{}{
+				"id":            uuid.New().String(),
+				"username":      username,
+				"password":      password,
+				"email":         email,
+				"emailVerified": emailVerified,
+				"provider":      provider,
+				"subject":       subject,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "created", ErrIdentityLinked)
+	})
+	switch {
+	case isConstraintViolation(err, "User", "username"):
+		return ErrUsernameTaken
+	case isConstraintViolation(err, "Identity", "subject"):
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// VerifyEmail marca como verificado el email del usuario si sigue siendo
+// email.
+func (r *userRepository) VerifyEmail(username, email string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE u.email = $email
+		 SET u.emailVerified = true
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "email": email},
+		ErrEmailChanged,
+	)
+}
+
+// GetUsersByEmail devuelve los usuarios con ese email. El email no es unico,
+// asi que puede haber varios.
+func (r *userRepository) GetUsersByEmail(email string) ([]*data.User, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			"MATCH (u:User {email: $email}) RETURN "+userFields,
+			map[string]interface{}{"email": email},
+		)
+		if err != nil {
+			return nil, err
+		}
+		var users []*data.User
+		for result.Next() {
+			users = append(users, userFromRecord(result.Record()))
+		}
+		return users, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*data.User), nil
+}
+
+// SetPendingTOTP guarda un secreto TOTP nuevo hasta que el usuario lo
+// confirme con EnableTOTP. No cambia la verificacion en dos pasos actual.
+func (r *userRepository) SetPendingTOTP(username, secret string) error {
+	return r.updateUser(
+		"MATCH (u:User {username: $username}) SET u.totpPendingSecret = $secret RETURN count(u) AS updated",
+		map[string]interface{}{"username": username, "secret": secret},
+		ErrUserNotFound,
+	)
+}
+
+// EnableTOTP activa la verificacion en dos pasos con el secreto pendiente si
+// sigue siendo secret, y guarda los hashes de los codigos de recuperacion.
+// counter es el periodo del codigo con el que se confirmo, que ya no sirve
+// para iniciar sesion.
+func (r *userRepository) EnableTOTP(username, secret string, counter int64, recoveryCodes []string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE u.totpPendingSecret = $secret
+		 SET u.totpEnabled = true, u.totpSecret = $secret, u.recoveryCodes = $recoveryCodes,
+		     u.totpLastCounter = $counter
+		 REMOVE u.totpPendingSecret
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "secret": secret, "counter": counter, "recoveryCodes": recoveryCodes},
+		ErrNoPendingTOTP,
+	)
+}
+
+func (r *userRepository) DisableTOTP(username string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username})
+		 SET u.totpEnabled = false
+		 REMOVE u.totpSecret, u.totpPendingSecret, u.totpLastCounter, u.recoveryCodes
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username},
+		ErrUserNotFound,
+	)
+}
+
+// UseTOTPCounter guarda el periodo del ultimo codigo TOTP aceptado. Devuelve
+// ErrTOTPCodeUsed si ya se acepto un codigo de ese periodo o posterior, para
+// que un codigo interceptado no se pueda reutilizar.
+func (r *userRepository) UseTOTPCounter(username string, counter int64) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE coalesce(u.totpLastCounter, -1) < $counter
+		 SET u.totpLastCounter = $counter
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "counter": counter},
+		ErrTOTPCodeUsed,
+	)
+}
+
+// UseRecoveryCode borra el hash de un codigo de recuperacion usado.
+func (r *userRepository) UseRecoveryCode(username, codeHash string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE $codeHash IN u.recoveryCodes
+		 SET u.recoveryCodes = [c IN u.recoveryCodes WHERE c <> $codeHash]
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username, "codeHash": codeHash},
+		ErrRecoveryCodeUsed,
+	)
+}
+
+// ScheduleDeletion programa el borrado de la cuenta. Hasta deleteAfter se
+// puede deshacer con CancelDeletion.
+func (r *userRepository) ScheduleDeletion(username string, deleteAfter time.Time) error {
+	return r.updateUser(
+		"MATCH (u:User {username: $username}) SET u.deleteAfter = $deleteAfter RETURN count(u) AS updated",
+		map[string]interface{}{"username": username, "deleteAfter": deleteAfter.UnixMilli()},
+		ErrUserNotFound,
+	)
+}
+
+func (r *userRepository) CancelDeletion(username string) error {
+	return r.updateUser(
+		`MATCH (u:User {username: $username}) WHERE u.deleteAfter IS NOT NULL
+		 REMOVE u.deleteAfter
+		 RETURN count(u) AS updated`,
+		map[string]interface{}{"username": username},
+		ErrNoPendingDelete,
+	)
+}
+
+func (r *userRepository) updateUser(query string, params map[string]interface{}, notFound error) error {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	_, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(query, params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, expectUpdated(result, "updated", notFound)
+	})
+	return err
+}
+
+// GetUsersToDelete devuelve los usuarios cuyo periodo para deshacer el
+// borrado termino antes de now.
+func (r *userRepository) GetUsersToDelete(now time.Time) ([]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+
+	result, err := session.ReadTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			"MATCH (u:User) WHERE u.deleteAfter <= $now RETURN u.username AS username",
+			map[string]interface{}{"now": now.UnixMilli()},
+		)
+		if err != nil {
+			return nil, err
+		}
+		var usernames []string
+		for result.Next() {
+			usernames = append(usernames, recordString(result.Record(), "username"))
+		}
+		return usernames, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]string), nil
+}
+
+// DeleteUser borra en una sola transaccion al usuario, sus posts (con sus
+// comentarios, versiones y adjuntos), sus comentarios en otros posts con sus
+// respuestas, sus identidades externas, sus API keys y todas sus relaciones
+// (amistades, likes, reacciones). Devuelve las keys de los archivos del
+// usuario para borrarlos del storage.
+func (r *userRepository) DeleteUser(username string) ([]string, error) {
+	session := r.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	params := map[string]interface{}{"username": username}
+	result, err := session.WriteTransaction(func(transaction neo4j.Transaction) (interface{}, error) {
+		result, err := transaction.Run(
+			"MATCH (u:User {username: $username}) RETURN u.avatarKeys AS keys",
+			params,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrUserNotFound
+		}
+		keys := recordStrings(result.Record(), "keys")
+
+		result, err = transaction.Run(
+			"MATCH (:User {username: $username})-[:POSTED]->(p:Post)"+deletePostsQuery,
+			params,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			keys = append(keys, recordStrings(result.Record(), "keys")...)
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+
+		_, err = transaction.Run(
+			`MATCH (:User {username: $username})-[:WROTE]->(c:Comment)
+			 OPTIONAL MATCH (reply:Comment)-[:REPLY_TO*]->(c)
+			 WITH collect(c) + collect(reply) AS comments
+			 UNWIND comments AS c
+			 WITH DISTINCT c
+			 DETACH DELETE c`,
+			params,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = transaction.Run(
+			`MATCH (u:User {username: $username})
+			 OPTIONAL MATCH (u)-[:HAS_IDENTITY]->(i:Identity)
+			 WITH u, collect(i) AS identities
+			 OPTIONAL MATCH (u)-[:HAS_API_KEY]->(k:APIKey)
+			 WITH u, identities + collect(k) AS nodes
+			 FOREACH (n IN nodes | DETACH DELETE n)
+			 DETACH DELETE u`,
+			params,
+		)
+		return keys, err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return result.(map[string]interface{}), nil
+	return result.([]string), nil
 }
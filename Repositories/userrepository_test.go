@@ -0,0 +1,75 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func TestSetRolesKeepsLastAdmin(t *testing.T) {
+	driver, prefix := newTestDriver(t)
+	users := NewUserRepository(driver)
+
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close()
+	result, err := session.Run(
+		`MATCH (u:User) WHERE $admin IN coalesce(u.roles, []) RETURN count(u) AS admins`,
+		map[string]interface{}{"admin": data.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := result.Single()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recordInt(record, "admins") > 0 {
+		t.Skip("la base de datos de test ya tiene admins")
+	}
+
+	admins := []string{prefix + "alice", prefix + "bob"}
+	for _, username := range admins {
+		if err := users.CreateUser(username, "hash", username+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := users.AddRole(username, data.RoleAdmin); err != nil {
+			t.Fatalf("AddRole: %v", err)
+		}
+	}
+
+	// Si se quitan a la vez los dos ultimos admins solo puede salir bien uno.
+	errs := make([]error, len(admins))
+	var wg sync.WaitGroup
+	for i, username := range admins {
+		wg.Add(1)
+		go func(i int, username string) {
+			defer wg.Done()
+			errs[i] = users.SetRoles(username, []string{data.RoleUser})
+		}(i, username)
+	}
+	wg.Wait()
+
+	var refused int
+	remaining := ""
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, ErrLastAdmin):
+			refused++
+			remaining = admins[i]
+		case err != nil:
+			t.Fatalf("SetRoles: %v", err)
+		}
+	}
+	if refused != 1 {
+		t.Fatalf("SetRoles rechazado %d veces, se esperaba 1", refused)
+	}
+
+	if err := users.SetRoles(remaining, []string{data.RoleUser}); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("quitar el ultimo admin = %v, se esperaba ErrLastAdmin", err)
+	}
+	if err := users.SetRoles(remaining, []string{data.RoleUser, data.RoleAdmin, data.RoleModerator}); err != nil {
+		t.Errorf("cambiar los roles del ultimo admin sin quitarle admin: %v", err)
+	}
+}
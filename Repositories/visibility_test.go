@@ -0,0 +1,326 @@
+package Repositories
+
+import (
+	data "SocialMedia/Data"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// newTestDriver conecta con la base de datos de NEO4J_TEST_URI o salta el
+// test si no esta definida. Devuelve un prefijo para los usuarios del test,
+// que se borran con sus posts y comentarios al terminar.
+func newTestDriver(t *testing.T) (neo4j.Driver, string) {
+	t.Helper()
+	uri := os.Getenv("NEO4J_TEST_URI")
+	if uri == "" {
+		t.Skip("NEO4J_TEST_URI no definida")
+	}
+	driver, err := neo4j.NewDriver(uri, neo4j.BasicAuth(os.Getenv("NEO4J_TEST_USERNAME"), os.Getenv("NEO4J_TEST_PASSWORD"), ""))
+	if err != nil {
+		t.Fatalf("Error creating Neo4j driver: %v", err)
+	}
+
+	prefix := "t" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+	t.Cleanup(func() {
+		defer driver.Close()
+		session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+		defer session.Close()
+		_, err := session.Run(
+			`MATCH (u:User) WHERE u.username STARTS WITH $prefix
+			 OPTIONAL MATCH (u)-[:POSTED]->(p:Post)
+			 OPTIONAL MATCH (c:Comment)-[:ON]->(p)
+			 DETACH DELETE c, p, u`,
+			map[string]interface{}{"prefix": prefix})
+		if err != nil {
+			t.Errorf("Error borrando los datos del test: %v", err)
+		}
+	})
+	return driver, prefix
+}
+
+// visibilityFixture crea un autor con un post de cada visibilidad y usuarios
+// con cada relacion posible con el:
+//
+//	friend:   amigo del autor
+//	fof:      amigo de friend
+//	listed:   unico usuario en visibleTo del post custom
+//	stranger: sin relacion
+//	blocked:  bloqueado por el autor
+type visibilityFixture struct {
+	users   UserRepository
+	friends FriendsRepository
+	posts   PostsRepository
+	prefix  string
+}
+
+func newVisibilityFixture(t *testing.T) *visibilityFixture {
+	driver, prefix := newTestDriver(t)
+	f := &visibilityFixture{
+		users:   NewUserRepository(driver),
+		friends: NewFriendsRepository(driver),
+		posts:   NewPostsRepository(driver),
+		prefix:  prefix,
+	}
+
+	for _, name := range []string{"author", "friend", "fof", "listed", "stranger", "blocked"} {
+		if err := f.users.CreateUser(f.user(name), "hash", f.user(name)+"@example.com"); err != nil {
+			t.Fatalf("CreateUser %s: %v", name, err)
+		}
+	}
+	f.befriend(t, "author", "friend")
+	f.befriend(t, "friend", "fof")
+	if err := f.friends.BlockUser(f.user("author"), f.user("blocked")); err != nil {
+		t.Fatalf("BlockUser: %v", err)
+	}
+
+	for _, visibility := range data.Visibilities {
+		post := data.Post{
+			ID:         f.postID(visibility),
+			Content:    visibility,
+			Visibility: visibility,
+			CreatedAt:  time.Now(),
+		}
+		if visibility == data.VisibilityCustom {
+			post.VisibleTo = []string{f.user("listed")}
+		}
+		if err := f.posts.CreatePost(f.user("author"), post); err != nil {
+			t.Fatalf("CreatePost %s: %v", visibility, err)
+		}
+	}
+	return f
+}
+
+func (f *visibilityFixture) user(name string) string {
+	return f.prefix + name
+}
+
+func (f *visibilityFixture) postID(visibility string) string {
+	return f.prefix + "-" + visibility
+}
+
+func (f *visibilityFixture) befriend(t *testing.T, sent, received string) {
+	t.Helper()
+	if err := f.friends.AddFriend(f.user(sent), f.user(received)); err != nil {
+		t.Fatalf("AddFriend %s -> %s: %v", sent, received, err)
+	}
+	if err := f.friends.AcceptFriendRequest(f.user(sent), f.user(received)); err != nil {
+		t.Fatalf("AcceptFriendRequest %s -> %s: %v", sent, received, err)
+	}
+}
+
+// checkVisible comprueba que viewer ve o no el post de cada visibilidad
+// segun want.
+func (f *visibilityFixture) checkVisible(t *testing.T, viewer string, want map[string]bool) {
+	t.Helper()
+	for _, visibility := range data.Visibilities {
+		err := f.posts.CheckPostVisible(f.user(viewer), f.postID(visibility))
+		if err != nil && !errors.Is(err, ErrPostNotFound) {
+			t.Fatalf("CheckPostVisible(%s, %s): %v", viewer, visibility, err)
+		}
+		if visible := err == nil; visible != want[visibility] {
+			t.Errorf("%s ve el post %s = %v, se esperaba %v", viewer, visibility, visible, want[visibility])
+		}
+	}
+}
+
+func TestPostVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+
+	tests := []struct {
+		viewer  string
+		visible []string
+	}{
+		{"author", data.Visibilities},
+		{"friend", []string{data.VisibilityPublic, data.VisibilityFriends, data.VisibilityFriendsOfFriends}},
+		{"fof", []string{data.VisibilityPublic, data.VisibilityFriendsOfFriends}},
+		{"listed", []string{data.VisibilityPublic, data.VisibilityCustom}},
+		{"stranger", []string{data.VisibilityPublic}},
+		{"blocked", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.viewer, func(t *testing.T) {
+			want := make(map[string]bool)
+			for _, visibility := range tt.visible {
+				want[visibility] = true
+			}
+			f.checkVisible(t, tt.viewer, want)
+		})
+	}
+}
+
+func TestPrivateProfileVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	if _, err := f.users.UpdateProfile(f.user("author"), data.ProfileUpdate{Bio: stringPtr("bio")}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	settings := data.PrivacySettings{PrivateProfile: true, FriendRequests: data.FriendRequestsEveryone}
+	if err := f.users.UpdatePrivacy(f.user("author"), settings); err != nil {
+		t.Fatalf("UpdatePrivacy: %v", err)
+	}
+
+	// Con el perfil privado los posts public y friends_of_friends solo los
+	// ven los amigos; los custom siguen viendolos los usuarios elegidos.
+	tests := []struct {
+		viewer      string
+		visible     []string
+		fullProfile bool
+	}{
+		{"author", data.Visibilities, true},
+		{"friend", []string{data.VisibilityPublic, data.VisibilityFriends, data.VisibilityFriendsOfFriends}, true},
+		{"fof", nil, false},
+		{"listed", []string{data.VisibilityCustom}, false},
+		{"stranger", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.viewer, func(t *testing.T) {
+			want := make(map[string]bool)
+			for _, visibility := range tt.visible {
+				want[visibility] = true
+			}
+			f.checkVisible(t, tt.viewer, want)
+
+			profile, err := f.users.GetProfile(f.user(tt.viewer), f.user("author"))
+			if err != nil || profile == nil {
+				t.Fatalf("GetProfile = %v, %v", profile, err)
+			}
+			if !profile.Private {
+				t.Errorf("el perfil no aparece como privado")
+			}
+			if full := profile.Bio != ""; full != tt.fullProfile {
+				t.Errorf("%s ve el perfil completo = %v, se esperaba %v", tt.viewer, full, tt.fullProfile)
+			}
+		})
+	}
+}
+
+func TestFriendRequestPolicy(t *testing.T) {
+	f := newVisibilityFixture(t)
+
+	tests := []struct {
+		policy  string
+		sender  string
+		allowed bool
+	}{
+		{data.FriendRequestsEveryone, "stranger", true},
+		{data.FriendRequestsEveryone, "fof", true},
+		{data.FriendRequestsFriendsOfFriends, "stranger", false},
+		{data.FriendRequestsFriendsOfFriends, "fof", true},
+		{data.FriendRequestsNobody, "stranger", false},
+		{data.FriendRequestsNobody, "fof", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.sender, func(t *testing.T) {
+			settings := data.PrivacySettings{FriendRequests: tt.policy}
+			if err := f.users.UpdatePrivacy(f.user("author"), settings); err != nil {
+				t.Fatalf("UpdatePrivacy: %v", err)
+			}
+
+			err := f.friends.AddFriend(f.user(tt.sender), f.user("author"))
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("AddFriend: %v", err)
+				}
+				if err := f.friends.CancelFriendRequest(f.user(tt.sender), f.user("author")); err != nil {
+					t.Fatalf("CancelFriendRequest: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrFriendRequestsNotAllowed) {
+				t.Errorf("AddFriend = %v, se esperaba ErrFriendRequestsNotAllowed", err)
+			}
+		})
+	}
+}
+
+func TestBlockedUsersHiddenFromPost(t *testing.T) {
+	f := newVisibilityFixture(t)
+	postID := f.postID(data.VisibilityPublic)
+
+	if err := f.posts.SetReaction(f.user("stranger"), postID, data.ReactionLike); err != nil {
+		t.Fatalf("SetReaction: %v", err)
+	}
+	if err := f.posts.SetReaction(f.user("friend"), postID, data.ReactionLike); err != nil {
+		t.Fatalf("SetReaction: %v", err)
+	}
+	parent, err := f.posts.CreateComment(f.user("stranger"), data.Comment{ID: f.prefix + "-parent", PostID: postID, Content: "hola"})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	reply := data.Comment{ID: f.prefix + "-reply", PostID: postID, ParentID: parent.ID, Content: "hola"}
+	if _, err := f.posts.CreateComment(f.user("friend"), reply); err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	if err := f.friends.BlockUser(f.user("fof"), f.user("stranger")); err != nil {
+		t.Fatalf("BlockUser: %v", err)
+	}
+
+	// fof ha bloqueado a stranger: no cuenta su like ni su comentario, no
+	// ve el comentario y no puede pedir sus respuestas.
+	tests := []struct {
+		viewer       string
+		likes        int
+		commentCount int
+		topLevel     int
+		parentErr    error
+	}{
+		{"friend", 2, 2, 1, nil},
+		{"fof", 1, 1, 0, ErrCommentNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.viewer, func(t *testing.T) {
+			posts, err := f.posts.GetUserPost(f.user(tt.viewer), f.user("author"))
+			if err != nil {
+				t.Fatalf("GetUserPost: %v", err)
+			}
+			found := false
+			for _, post := range posts {
+				if post.ID != postID {
+					continue
+				}
+				found = true
+				if post.Likes != tt.likes || post.Reactions[data.ReactionLike] != tt.likes {
+					t.Errorf("likes = %d, reacciones = %v, se esperaban %d", post.Likes, post.Reactions, tt.likes)
+				}
+				if post.CommentCount != tt.commentCount {
+					t.Errorf("commentCount = %d, se esperaba %d", post.CommentCount, tt.commentCount)
+				}
+			}
+			if !found {
+				t.Fatalf("%s no ve el post", tt.viewer)
+			}
+
+			reactions, err := f.posts.GetReactions(f.user(tt.viewer), postID, "")
+			if err != nil {
+				t.Fatalf("GetReactions: %v", err)
+			}
+			if len(reactions) != tt.likes {
+				t.Errorf("GetReactions = %v, se esperaban %d", reactions, tt.likes)
+			}
+
+			comments, err := f.posts.GetComments(f.user(tt.viewer), postID, "", 0, 10)
+			if err != nil {
+				t.Fatalf("GetComments: %v", err)
+			}
+			if len(comments) != tt.topLevel {
+				t.Errorf("GetComments = %v, se esperaban %d", comments, tt.topLevel)
+			}
+
+			// Las respuestas a un comentario oculto tampoco se pueden pedir
+			// con su id.
+			_, err = f.posts.GetComments(f.user(tt.viewer), postID, parent.ID, 0, 10)
+			if !errors.Is(err, tt.parentErr) {
+				t.Errorf("GetComments de las respuestas = %v, se esperaba %v", err, tt.parentErr)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	service "SocialMedia/Service"
+	"SocialMedia/middleware"
+	"net/http"
+)
+
+// APIKeyRoutes solo aceptan la sesion del usuario: una API key no puede
+// crear ni revocar otras.
+func APIKeyRoutes(mux *http.ServeMux, apiKeyService service.APIKeyService) {
+	mux.Handle("POST /users/me/api-keys", middleware.AuthMiddleware(http.HandlerFunc(apiKeyService.CreateAPIKey)))
+	mux.Handle("GET /users/me/api-keys", middleware.AuthMiddleware(http.HandlerFunc(apiKeyService.GetAPIKeys)))
+	mux.Handle("DELETE /users/me/api-keys/{id}", middleware.AuthMiddleware(http.HandlerFunc(apiKeyService.RevokeAPIKey)))
+}
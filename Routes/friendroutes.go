@@ -1,14 +1,25 @@
 package routes
 
 import (
+	data "SocialMedia/Data"
 	service "SocialMedia/Service"
 	"SocialMedia/middleware"
 	"net/http"
 )
 
 func FriendRoutes(mux *http.ServeMux, friendService service.FriendsService) {
-	mux.Handle("POST /friends", middleware.AuthMiddleware(http.HandlerFunc(friendService.AddFriend)))
-	mux.Handle("DELETE /friends", middleware.AuthMiddleware(http.HandlerFunc(friendService.DeleteFriend)))
-	mux.Handle("GET /friends", middleware.AuthMiddleware(http.HandlerFunc(friendService.GetFriends)))
-	mux.Handle("POST /friends/accept", middleware.AuthMiddleware(http.HandlerFunc(friendService.AcceptFriendRequest)))
+	mux.Handle("POST /friends", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.AddFriend)))
+	mux.Handle("DELETE /friends", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.DeleteFriend)))
+	mux.Handle("GET /friends", middleware.Scoped(data.ScopeFriendsRead)(http.HandlerFunc(friendService.GetFriends)))
+	mux.Handle("POST /friends/accept", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.AcceptFriendRequest)))
+	mux.Handle("POST /friends/decline", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.DeclineFriendRequest)))
+	mux.Handle("POST /friends/cancel", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.CancelFriendRequest)))
+	mux.Handle("GET /friends/requests/incoming", middleware.Scoped(data.ScopeFriendsRead)(http.HandlerFunc(friendService.GetIncomingRequests)))
+	mux.Handle("GET /friends/requests/outgoing", middleware.Scoped(data.ScopeFriendsRead)(http.HandlerFunc(friendService.GetOutgoingRequests)))
+	mux.Handle("POST /blocks", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.BlockUser)))
+	mux.Handle("DELETE /blocks", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.UnblockUser)))
+	mux.Handle("GET /blocks", middleware.Scoped(data.ScopeFriendsRead)(http.HandlerFunc(friendService.GetBlockedUsers)))
+	mux.Handle("POST /mutes", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.MuteUser)))
+	mux.Handle("DELETE /mutes", middleware.Scoped(data.ScopeFriendsWrite)(http.HandlerFunc(friendService.UnmuteUser)))
+	mux.Handle("GET /mutes", middleware.Scoped(data.ScopeFriendsRead)(http.HandlerFunc(friendService.GetMutedUsers)))
 }
@@ -1,16 +1,32 @@
 package routes
 
 import (
+	data "SocialMedia/Data"
 	service "SocialMedia/Service"
 	"SocialMedia/middleware"
 	"net/http"
 )
 
 func PostRoutes(mux *http.ServeMux, postService service.PostService) {
-	mux.Handle("POST /posts/create", middleware.AuthMiddleware(http.HandlerFunc(postService.CreatePost)))
-	mux.Handle("GET /posts/{id}", middleware.AuthMiddleware(http.HandlerFunc(postService.GetUserPosts)))
-	mux.Handle("DELETE /posts/{id}", middleware.AuthMiddleware(http.HandlerFunc(postService.DeletePost)))
-	mux.Handle("GET /posts/friends", middleware.AuthMiddleware(http.HandlerFunc(postService.GetFriendsPosts)))
-	mux.Handle("POST /posts/like", middleware.AuthMiddleware(http.HandlerFunc(postService.LikePost)))
-	mux.Handle("GET /posts/likes", middleware.AuthMiddleware(http.HandlerFunc(postService.GetLikesFromPost)))
+	mux.Handle("POST /posts/create", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.CreatePost)))
+	mux.Handle("GET /posts/{id}", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetUserPosts)))
+	mux.Handle("PATCH /posts/{id}", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.UpdatePost)))
+	mux.Handle("PUT /posts/{id}/visibility", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.SetPostVisibility)))
+	mux.Handle("GET /posts/{id}/history", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetPostHistory)))
+	mux.Handle("DELETE /posts/{id}", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.DeletePost)))
+	mux.Handle("GET /posts/friends", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetFriendsPosts)))
+	mux.Handle("POST /posts/like", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.LikePost)))
+	mux.Handle("DELETE /posts/{id}/like", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.UnlikePost)))
+	mux.Handle("GET /posts/likes", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetLikesFromPost)))
+	mux.Handle("PUT /posts/{id}/reaction", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.SetReaction)))
+	mux.Handle("DELETE /posts/{id}/reaction", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.RemoveReaction)))
+	mux.Handle("GET /posts/{id}/reactions", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetReactions)))
+	mux.Handle("POST /posts/{id}/comments", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.CreateComment)))
+	mux.Handle("GET /posts/{id}/comments", middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(postService.GetComments)))
+	mux.Handle("PATCH /comments/{id}", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.UpdateComment)))
+	mux.Handle("DELETE /comments/{id}", middleware.Scoped(data.ScopePostsWrite)(http.HandlerFunc(postService.DeleteComment)))
+
+	moderator := middleware.RequireRole(data.RoleModerator)
+	mux.Handle("DELETE /moderation/posts/{id}", middleware.AuthMiddleware(moderator(http.HandlerFunc(postService.ModeratePost))))
+	mux.Handle("DELETE /moderation/comments/{id}", middleware.AuthMiddleware(moderator(http.HandlerFunc(postService.ModerateComment))))
 }
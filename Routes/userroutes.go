@@ -1,11 +1,39 @@
 package routes
 
 import (
+	data "SocialMedia/Data"
 	service "SocialMedia/Service"
+	"SocialMedia/middleware"
 	"net/http"
 )
 
 func AuthRoutes(mux *http.ServeMux, userService service.UserService) {
 	mux.HandleFunc("/register", userService.Register)
 	mux.HandleFunc("/login", userService.LoginUser)
+	mux.HandleFunc("POST /login/2fa", userService.LoginTwoFactor)
+	mux.HandleFunc("GET /auth/oidc/{provider}/login", userService.OIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/{provider}/callback", userService.OIDCCallback)
+	mux.HandleFunc("POST /token/refresh", userService.RefreshToken)
+	mux.HandleFunc("GET /.well-known/jwks.json", service.JWKS)
+	mux.HandleFunc("POST /verify-email", userService.VerifyEmail)
+	mux.Handle("POST /verify-email/resend", middleware.AuthMiddleware(http.HandlerFunc(userService.ResendVerification)))
+	mux.HandleFunc("POST /password/forgot", userService.ForgotPassword)
+	mux.HandleFunc("POST /password/reset", userService.ResetPassword)
+	mux.Handle("POST /logout", middleware.AuthMiddleware(http.HandlerFunc(userService.Logout)))
+	mux.Handle("GET /users/{username}", middleware.Scoped(data.ScopeProfileRead)(http.HandlerFunc(userService.GetProfile)))
+	mux.Handle("PATCH /users/me", middleware.AuthMiddleware(http.HandlerFunc(userService.UpdateProfile)))
+	mux.Handle("POST /users/me/avatar", middleware.AuthMiddleware(http.HandlerFunc(userService.UploadAvatar)))
+	mux.Handle("POST /users/me/password", middleware.AuthMiddleware(http.HandlerFunc(userService.ChangePassword)))
+	mux.Handle("POST /users/me/email", middleware.AuthMiddleware(http.HandlerFunc(userService.ChangeEmail)))
+	mux.HandleFunc("POST /email/confirm", userService.ConfirmEmailChange)
+	mux.Handle("DELETE /users/me", middleware.AuthMiddleware(http.HandlerFunc(userService.DeleteAccount)))
+	mux.Handle("POST /users/me/restore", middleware.AuthMiddleware(http.HandlerFunc(userService.RestoreAccount)))
+	mux.Handle("POST /users/me/2fa/setup", middleware.AuthMiddleware(http.HandlerFunc(userService.SetupTwoFactor)))
+	mux.Handle("POST /users/me/2fa/confirm", middleware.AuthMiddleware(http.HandlerFunc(userService.ConfirmTwoFactor)))
+	mux.Handle("DELETE /users/me/2fa", middleware.AuthMiddleware(http.HandlerFunc(userService.DisableTwoFactor)))
+	mux.Handle("GET /users/me/privacy", middleware.AuthMiddleware(http.HandlerFunc(userService.GetPrivacy)))
+	mux.Handle("PATCH /users/me/privacy", middleware.AuthMiddleware(http.HandlerFunc(userService.UpdatePrivacy)))
+
+	admin := middleware.RequireRole(data.RoleAdmin)
+	mux.Handle("PUT /admin/users/{username}/roles", middleware.AuthMiddleware(admin(http.HandlerFunc(userService.SetRoles))))
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeys          = 20
+	maxAPIKeyNameLength = 50
+)
+
+type APIKeyService interface {
+	CreateAPIKey(w http.ResponseWriter, r *http.Request)
+	GetAPIKeys(w http.ResponseWriter, r *http.Request)
+	RevokeAPIKey(w http.ResponseWriter, r *http.Request)
+}
+
+type apiKeyService struct {
+	apiKeyRepo Repositories.APIKeyRepository
+}
+
+func NewAPIKeyService(repo Repositories.APIKeyRepository) APIKeyService {
+	return &apiKeyService{repo}
+}
+
+// CreateAPIKey crea una API key con los scopes pedidos. La clave solo se
+// devuelve en esta respuesta; despues solo se guarda su hash.
+func (s *apiKeyService) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > maxAPIKeyNameLength {
+		http.Error(w, "el nombre es obligatorio y no puede superar 50 caracteres", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "la API key necesita al menos un scope", http.StatusBadRequest)
+		return
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !data.IsValidScope(scope) {
+			http.Error(w, "scope invalido: "+scope, http.StatusBadRequest)
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	keys, err := s.apiKeyRepo.GetAPIKeys(username)
+	if err != nil {
+		log.Printf("Error obteniendo las API keys: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) >= maxAPIKeys {
+		http.Error(w, "has llegado al maximo de API keys", http.StatusConflict)
+		return
+	}
+
+	secret, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		log.Printf("Error generando la API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	key := data.APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    secret[:utils.APIKeyDisplayLength],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := s.apiKeyRepo.CreateAPIKey(username, hash, key); err != nil {
+		log.Printf("Error creando la API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"key": secret, "apiKey": key}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func (s *apiKeyService) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	keys, err := s.apiKeyRepo.GetAPIKeys(username)
+	if err != nil {
+		log.Printf("Error obteniendo las API keys: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func (s *apiKeyService) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.apiKeyRepo.RevokeAPIKey(username, r.PathValue("id")); err != nil {
+		if errors.Is(err, Repositories.ErrAPIKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error revocando la API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	data "SocialMedia/Data"
 	"SocialMedia/Repositories"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 )
@@ -11,7 +13,17 @@ type FriendsService interface {
 	AddFriend(w http.ResponseWriter, r *http.Request)
 	DeleteFriend(w http.ResponseWriter, r *http.Request)
 	AcceptFriendRequest(w http.ResponseWriter, r *http.Request)
+	DeclineFriendRequest(w http.ResponseWriter, r *http.Request)
+	CancelFriendRequest(w http.ResponseWriter, r *http.Request)
 	GetFriends(w http.ResponseWriter, r *http.Request)
+	GetIncomingRequests(w http.ResponseWriter, r *http.Request)
+	GetOutgoingRequests(w http.ResponseWriter, r *http.Request)
+	BlockUser(w http.ResponseWriter, r *http.Request)
+	UnblockUser(w http.ResponseWriter, r *http.Request)
+	GetBlockedUsers(w http.ResponseWriter, r *http.Request)
+	MuteUser(w http.ResponseWriter, r *http.Request)
+	UnmuteUser(w http.ResponseWriter, r *http.Request)
+	GetMutedUsers(w http.ResponseWriter, r *http.Request)
 }
 
 type friendsService struct {
@@ -22,82 +34,155 @@ func NewFriendsService(fr Repositories.FriendsRepository) FriendsService {
 	return &friendsService{fr}
 }
 
-func (s *friendsService) AddFriend(w http.ResponseWriter, r *http.Request) {
-	var friendRequest struct {
-		UsernameSent     string `json:"usernamesent"`
-		UsernameReceived string `json:"usernamereceived"`
+var errForbiddenFriendAction = errors.New("no puedes gestionar una solicitud de amistad de otro usuario")
+
+// friendRequestBody solo contiene el otro usuario de la relacion; quien
+// actua es siempre el usuario autenticado.
+type friendRequestBody struct {
+	Username string `json:"username"`
+
+	// UsernameSent y UsernameReceived son los campos anteriores, con los dos
+	// usuarios de la solicitud. Se aceptan durante una version para no romper
+	// los clientes existentes; uno de los dos debe ser el usuario
+	// autenticado.
+	UsernameSent     string `json:"usernamesent"`
+	UsernameReceived string `json:"usernamereceived"`
+}
+
+// decodeFriendRequest devuelve el usuario autenticado y el otro usuario de la
+// relacion indicado en el cuerpo.
+func decodeFriendRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return "", "", false
 	}
+
+	var friendRequest friendRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&friendRequest); err != nil {
 		log.Printf("Error decoding request body: %v", err)
 		http.Error(w, "request body invalid", http.StatusBadRequest)
-		return
+		return "", "", false
 	}
 	defer r.Body.Close()
-	if err := s.FriendRepo.AddFriend(friendRequest.UsernameSent, friendRequest.UsernameReceived); err != nil {
 
-		log.Printf("Error adding friend: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	other := friendRequest.Username
+	if other == "" && (friendRequest.UsernameSent != "" || friendRequest.UsernameReceived != "") {
+		w.Header().Set("Deprecation", "true")
+		switch username {
+		case friendRequest.UsernameSent:
+			other = friendRequest.UsernameReceived
+		case friendRequest.UsernameReceived:
+			other = friendRequest.UsernameSent
+		default:
+			http.Error(w, errForbiddenFriendAction.Error(), http.StatusForbidden)
+			return "", "", false
+		}
+	}
+	if other == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return "", "", false
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	return username, other, true
+}
 
-	if _, err := w.Write([]byte(`{"message": "Friend added"}`)); err != nil {
-		log.Printf("Error writing response: %v", err)
+func (s *friendsService) AddFriend(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.AddFriend(username, other); err != nil {
+		writeFriendError(w, "Error adding friend", err)
+		return
 	}
+
+	writeFriendMessage(w, "Friend request sent")
 }
 
 func (s *friendsService) DeleteFriend(w http.ResponseWriter, r *http.Request) {
-	var friendRequest struct {
-		UsernameSent     string `json:"usernamesent"`
-		UsernameReceived string `json:"usernamereceived"`
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&friendRequest); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "request body invalid", http.StatusBadRequest)
+	if err := s.FriendRepo.DeleteFriend(username, other); err != nil {
+		writeFriendError(w, "Error deleting friend", err)
 		return
 	}
-	defer r.Body.Close()
-	if err := s.FriendRepo.DeleteFriend(friendRequest.UsernameSent, friendRequest.UsernameReceived); err != nil {
-		log.Printf("Error deleting friend: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+
+	writeFriendMessage(w, "Friend deleted")
+}
+
+// AcceptFriendRequest acepta la solicitud que el otro usuario envio al
+// usuario autenticado.
+func (s *friendsService) AcceptFriendRequest(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write([]byte(`{"message": "Friend deleted"}`)); err != nil {
-		log.Printf("Error writing response: %v", err)
+	if err := s.FriendRepo.AcceptFriendRequest(other, username); err != nil {
+		writeFriendError(w, "Error accepting friend", s.checkRequestRole(username, other, false, err))
+		return
 	}
+
+	writeFriendMessage(w, "Friend accepted")
 }
 
-func (s *friendsService) AcceptFriendRequest(w http.ResponseWriter, r *http.Request) {
-	var friendRequest struct {
-		UsernameSent     string `json:"usernamesent"`
-		UsernameReceived string `json:"usernamereceived"`
+func (s *friendsService) DeclineFriendRequest(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.DeclineFriendRequest(other, username); err != nil {
+		writeFriendError(w, "Error declining friend request", s.checkRequestRole(username, other, false, err))
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&friendRequest); err != nil {
 
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "request body invalid", http.StatusBadRequest)
+	writeFriendMessage(w, "Friend request declined")
+}
+
+// CancelFriendRequest cancela la solicitud que el usuario autenticado envio
+// al otro usuario.
+func (s *friendsService) CancelFriendRequest(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
-	if err := s.FriendRepo.AcceptFriendRequest(friendRequest.UsernameSent, friendRequest.UsernameReceived); err != nil {
-		log.Printf("Error accepting friend: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := s.FriendRepo.CancelFriendRequest(username, other); err != nil {
+		writeFriendError(w, "Error cancelling friend request", s.checkRequestRole(username, other, true, err))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write([]byte(`{"message": "Friend accepted"}`)); err != nil {
-		log.Printf("Error writing response: %v", err)
+	writeFriendMessage(w, "Friend request cancelled")
+}
+
+// checkRequestRole convierte un ErrFriendRequestNotFound en
+// errForbiddenFriendAction cuando si existe una solicitud pendiente entre los
+// dos usuarios pero el usuario autenticado no tiene el rol necesario (por
+// ejemplo, intenta aceptar una solicitud que envio el mismo).
+func (s *friendsService) checkRequestRole(username, other string, mustBeSender bool, err error) error {
+	if !errors.Is(err, Repositories.ErrFriendRequestNotFound) {
+		return err
+	}
+
+	request, lookupErr := s.FriendRepo.GetFriendRequest(username, other)
+	if lookupErr != nil {
+		return lookupErr
 	}
+	if request != nil && request.Status == data.FriendRequestPending && (request.From == username) != mustBeSender {
+		return errForbiddenFriendAction
+	}
+	return err
 }
 
 func (s *friendsService) GetFriends(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
+	if username == "" {
+		current, ok := currentUsername(w, r)
+		if !ok {
+			return
+		}
+		username = current
+	}
 	friends, err := s.FriendRepo.GetFriendsList(username)
 	if err != nil {
 		log.Printf("Error getting friends list: %v", err)
@@ -110,3 +195,85 @@ func (s *friendsService) GetFriends(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+func (s *friendsService) GetIncomingRequests(w http.ResponseWriter, r *http.Request) {
+	username, ok := ownUsername(w, r)
+	if !ok {
+		return
+	}
+	requests, err := s.FriendRepo.GetIncomingRequests(username)
+	if err != nil {
+		log.Printf("Error getting incoming friend requests: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *friendsService) GetOutgoingRequests(w http.ResponseWriter, r *http.Request) {
+	username, ok := ownUsername(w, r)
+	if !ok {
+		return
+	}
+	requests, err := s.FriendRepo.GetOutgoingRequests(username)
+	if err != nil {
+		log.Printf("Error getting outgoing friend requests: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// ownUsername devuelve el usuario autenticado. Las solicitudes pendientes
+// solo las puede ver su propietario, asi que un ?username= distinto es 403.
+func ownUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return "", false
+	}
+	if requested := r.URL.Query().Get("username"); requested != "" && requested != username {
+		http.Error(w, "no puedes ver las solicitudes de otro usuario", http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
+
+func writeFriendMessage(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": message}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func writeFriendError(w http.ResponseWriter, logMessage string, err error) {
+	switch {
+	case errors.Is(err, Repositories.ErrUserNotFound),
+		errors.Is(err, Repositories.ErrFriendRequestNotFound),
+		errors.Is(err, Repositories.ErrNotFriends),
+		errors.Is(err, Repositories.ErrNotBlocked),
+		errors.Is(err, Repositories.ErrNotMuted):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, Repositories.ErrFriendRequestExists),
+		errors.Is(err, Repositories.ErrAlreadyFriends),
+		errors.Is(err, Repositories.ErrUserBlocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errForbiddenFriendAction),
+		errors.Is(err, Repositories.ErrFriendRequestsNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, Repositories.ErrSelfFriendRequest),
+		errors.Is(err, Repositories.ErrSelfRestriction):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: %v", logMessage, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
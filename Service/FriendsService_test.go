@@ -0,0 +1,46 @@
+package service
+
+import (
+	"SocialMedia/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFriendRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantOther  string
+		wantStatus int
+	}{
+		{"username", `{"username":"bob"}`, "bob", http.StatusOK},
+		{"legacy enviada", `{"usernamesent":"alice","usernamereceived":"bob"}`, "bob", http.StatusOK},
+		{"legacy recibida", `{"usernamesent":"bob","usernamereceived":"alice"}`, "bob", http.StatusOK},
+		{"legacy de otros usuarios", `{"usernamesent":"bob","usernamereceived":"carol"}`, "", http.StatusForbidden},
+		{"sin usuario", `{}`, "", http.StatusBadRequest},
+		{"cuerpo invalido", `{`, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/friends", strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: "alice"}))
+			rec := httptest.NewRecorder()
+
+			username, other, ok := decodeFriendRequest(rec, req)
+			if ok != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("ok = %v, status %d", ok, rec.Code)
+			}
+			if !ok {
+				if rec.Code != tt.wantStatus {
+					t.Errorf("status %d, se esperaba %d", rec.Code, tt.wantStatus)
+				}
+				return
+			}
+			if username != "alice" || other != tt.wantOther {
+				t.Errorf("decodeFriendRequest = %q, %q; se esperaba alice, %q", username, other, tt.wantOther)
+			}
+		})
+	}
+}
@@ -3,12 +3,15 @@ package service
 import (
 	data "SocialMedia/Data"
 	"SocialMedia/Repositories"
-	"SocialMedia/utils"
+	"SocialMedia/storage"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -16,26 +19,49 @@ import (
 type PostService interface {
 	CreatePost(w http.ResponseWriter, r *http.Request)
 	GetUserPosts(w http.ResponseWriter, r *http.Request)
+	UpdatePost(w http.ResponseWriter, r *http.Request)
+	SetPostVisibility(w http.ResponseWriter, r *http.Request)
+	GetPostHistory(w http.ResponseWriter, r *http.Request)
 	DeletePost(w http.ResponseWriter, r *http.Request)
 	GetFriendsPosts(w http.ResponseWriter, r *http.Request)
 	LikePost(w http.ResponseWriter, r *http.Request)
+	UnlikePost(w http.ResponseWriter, r *http.Request)
 	GetLikesFromPost(w http.ResponseWriter, r *http.Request)
+	SetReaction(w http.ResponseWriter, r *http.Request)
+	RemoveReaction(w http.ResponseWriter, r *http.Request)
+	GetReactions(w http.ResponseWriter, r *http.Request)
+	CreateComment(w http.ResponseWriter, r *http.Request)
+	GetComments(w http.ResponseWriter, r *http.Request)
+	UpdateComment(w http.ResponseWriter, r *http.Request)
+	DeleteComment(w http.ResponseWriter, r *http.Request)
+	ModeratePost(w http.ResponseWriter, r *http.Request)
+	ModerateComment(w http.ResponseWriter, r *http.Request)
 }
 
+const (
+	maxCommentLength = 2000
+	maxPostLength    = 5000
+	defaultPageSize  = 20
+	maxPageSize      = 100
+	maxVisibleTo     = 100
+)
+
 type postService struct {
 	friendRepo Repositories.FriendsRepository
 	postRepo   Repositories.PostsRepository
+	storage    storage.BlobStorage
 }
 
-func NewPostService(pr Repositories.PostsRepository, fr Repositories.FriendsRepository) PostService {
-	return &postService{postRepo: pr, friendRepo: fr}
+func NewPostService(pr Repositories.PostsRepository, fr Repositories.FriendsRepository, bs storage.BlobStorage) PostService {
+	return &postService{postRepo: pr, friendRepo: fr, storage: bs}
 }
 
 func (s *postService) CreatePost(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		log.Printf("Error al parsear el formulario multipart: %v", err)
-		http.Error(w, "Error al procesar la carga del archivo", http.StatusBadRequest)
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if !parseUploadForm(w, r) {
 		return
 	}
 
@@ -43,46 +69,50 @@ func (s *postService) CreatePost(w http.ResponseWriter, r *http.Request) {
 	newPost.ID = uuid.New().String()
 	newPost.Content = r.FormValue("content")
 	newPost.Likes = 0
+	newPost.Reactions = map[string]int{}
+	newPost.CreatedAt = time.Now()
+	newPost.UpdatedAt = newPost.CreatedAt
 
-	comments := r.FormValue("comments")
-	if comments != "" {
-		newPost.Comments = strings.Split(comments, ",")
+	visibility := r.FormValue("visibility")
+	if visibility == "" {
+		visibility = data.VisibilityPublic
 	}
-
-	file, header, err := r.FormFile("file")
+	visibleTo, err := validateVisibility(visibility, formList(r, "visibleTo"))
 	if err != nil {
-		log.Printf("Error al obtener el archivo: %v", err)
-		http.Error(w, "Error al obtener el archivo", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	newPost.Visibility = visibility
+	newPost.VisibleTo = visibleTo
 
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		log.Printf("Error al leer el archivo: %v", err)
-		http.Error(w, "Error al leer el archivo", http.StatusInternalServerError)
+	attachments := postAttachments(r.MultipartForm)
+	if len(attachments) > maxAttachments {
+		http.Error(w, fmt.Sprintf("Un post admite como maximo %d adjuntos", maxAttachments), http.StatusBadRequest)
+		return
+	}
+	if err := validatePostContent(newPost.Content, len(attachments) > 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	containerName := "posts"
-	blobURL, err := utils.UploadFileToBlobStorage(containerName, header.Filename, fileBytes)
+	media, err := uploadMedia(r.Context(), s.storage, "posts/"+newPost.ID, attachments)
 	if err != nil {
-		log.Printf("Error al subir el archivo a Blob Storage: %v", err)
-		http.Error(w, "Error al subir el archivo", http.StatusInternalServerError)
+		writeMediaError(w, err)
 		return
 	}
-	newPost.ImageURL = blobURL
-
-	username := r.Context().Value("username").(string)
+	newPost.Media = media
+	newPost.Author = username
 
 	if err := s.postRepo.CreatePost(username, newPost); err != nil {
+		deleteBlobs(r.Context(), s.storage, mediaKeys(newPost.Media))
 		log.Printf("Error creando post: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if _, err := w.Write([]byte("Post creado con éxito, imagen almacenada en: " + blobURL)); err != nil {
+	if err := json.NewEncoder(w).Encode(newPost); err != nil {
 		log.Printf("Error escribiendo respuesta: %v", err)
 	}
 }
@@ -94,7 +124,11 @@ func (s *postService) GetUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := s.postRepo.GetUserPost(username)
+	viewer, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	posts, err := s.postRepo.GetUserPost(viewer, username)
 	if err != nil {
 		log.Printf("Error obteniendo posts: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -108,20 +142,133 @@ func (s *postService) GetUserPosts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *postService) DeletePost(w http.ResponseWriter, r *http.Request) {
+// UpdatePost edita el contenido de un post propio. El contenido anterior se
+// conserva en el historial del post.
+func (s *postService) UpdatePost(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decodificando el cuerpo de la solicitud: %v", err)
+		http.Error(w, "Cuerpo de solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Si el contenido queda vacio, el repositorio comprueba que el post tenga
+	// adjuntos.
+	if err := validatePostContent(req.Content, true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	post, err := s.postRepo.UpdatePost(username, postID, req.Content)
+	if err != nil {
+		writePostError(w, "Error editando post", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// SetPostVisibility cambia quien puede ver un post propio. Con visibility
+// custom, visibleTo es la lista de usernames que lo pueden ver.
+func (s *postService) SetPostVisibility(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Visibility string   `json:"visibility"`
+		VisibleTo  []string `json:"visibleTo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decodificando el cuerpo de la solicitud: %v", err)
+		http.Error(w, "Cuerpo de solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	visibleTo, err := validateVisibility(req.Visibility, req.VisibleTo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	post, err := s.postRepo.SetPostVisibility(username, postID, req.Visibility, visibleTo)
+	if err != nil {
+		writePostError(w, "Error cambiando la visibilidad del post", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// GetPostHistory devuelve las versiones anteriores de un post propio.
+func (s *postService) GetPostHistory(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("id")
 	if postID == "" {
 		http.Error(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
 
-	username := r.Context().Value("username").(string)
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := s.postRepo.GetPostRevisions(username, postID)
+	if err != nil {
+		writePostError(w, "Error obteniendo historial del post", err)
+		return
+	}
 
-	if err := s.postRepo.DeletePost(username, postID); err != nil {
-		log.Printf("Error deleting post: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		log.Printf("Error encoding response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *postService) DeletePost(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.postRepo.DeletePost(username, postID)
+	if err != nil {
+		writePostError(w, "Error deleting post", err)
 		return
 	}
+	deleteBlobs(r.Context(), s.storage, keys)
 
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("Post deleted successfully")); err != nil {
@@ -129,28 +276,37 @@ func (s *postService) DeletePost(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetFriendsPosts devuelve el feed de posts de los amigos, del mas nuevo al
+// mas antiguo. Se pagina con ?limit= y con el ?cursor= devuelto en
+// nextCursor por la pagina anterior.
 func (s *postService) GetFriendsPosts(w http.ResponseWriter, r *http.Request) {
-	username := r.Context().Value("username").(string)
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
 
-	friends, err := s.friendRepo.GetFriendsList(username)
+	limit, err := parseLimit(r)
 	if err != nil {
-		log.Printf("Error obteniendo lista de amigos: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var friendsPosts []data.Post
-	for _, friend := range friends {
-		posts, err := s.postRepo.GetUserPost(friend)
-		if err != nil {
-			log.Printf("Error obteniendo posts del amigo %s: %v", friend, err)
-			continue
+	posts, nextCursor, err := s.postRepo.GetFeed(username, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, Repositories.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		friendsPosts = append(friendsPosts, posts...)
+		log.Printf("Error obteniendo el feed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(friendsPosts); err != nil {
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"posts":      posts,
+		"nextCursor": nextCursor,
+	}); err != nil {
 		log.Printf("Error encoding response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
@@ -167,10 +323,12 @@ func (s *postService) LikePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username := r.Context().Value("username").(string)
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
 	if err := s.postRepo.LikePost(username, req.PostID); err != nil {
-		log.Printf("Error dando like al post: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePostError(w, "Error dando like al post", err)
 		return
 	}
 
@@ -180,16 +338,41 @@ func (s *postService) LikePost(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *postService) UnlikePost(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.postRepo.UnlikePost(username, postID); err != nil {
+		writePostError(w, "Error quitando el like al post", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("Post unliked successfully")); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
 func (s *postService) GetLikesFromPost(w http.ResponseWriter, r *http.Request) {
 	postID := r.URL.Query().Get("postID")
 	if postID == "" {
 		http.Error(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
-	likes, err := s.postRepo.GetLikesFromPost(postID)
+	viewer, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	likes, err := s.postRepo.GetLikesFromPost(viewer, postID)
 	if err != nil {
-		log.Printf("Error obteniendo likes del post: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePostError(w, "Error obteniendo likes del post", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -198,3 +381,383 @@ func (s *postService) GetLikesFromPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// SetReaction guarda la reaccion del usuario al post, reemplazando la
+// anterior si la habia.
+func (s *postService) SetReaction(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decodificando el cuerpo de la solicitud: %v", err)
+		http.Error(w, "Cuerpo de solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !data.IsValidReaction(req.Type) {
+		http.Error(w, "tipo de reaccion invalido", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.postRepo.SetReaction(username, postID, req.Type); err != nil {
+		writePostError(w, "Error guardando la reaccion", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *postService) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.postRepo.RemoveReaction(username, postID, ""); err != nil {
+		writePostError(w, "Error quitando la reaccion", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetReactions lista quien reacciono al post y con que. Con ?type= se
+// filtra por un tipo de reaccion.
+func (s *postService) GetReactions(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	reactionType := r.URL.Query().Get("type")
+	if reactionType != "" && !data.IsValidReaction(reactionType) {
+		http.Error(w, "tipo de reaccion invalido", http.StatusBadRequest)
+		return
+	}
+
+	viewer, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	reactions, err := s.postRepo.GetReactions(viewer, postID, reactionType)
+	if err != nil {
+		writePostError(w, "Error obteniendo reacciones del post", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reactions); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *postService) CreateComment(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Content  string `json:"content"`
+		ParentID string `json:"parentID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decodificando el cuerpo de la solicitud: %v", err)
+		http.Error(w, "Cuerpo de solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	content, err := validateCommentContent(req.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	comment, err := s.postRepo.CreateComment(username, data.Comment{
+		ID:       uuid.New().String(),
+		PostID:   postID,
+		ParentID: req.ParentID,
+		Content:  content,
+	})
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(comment); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// GetComments lista los comentarios de un post paginados con offset y limit.
+// Con ?parent=<id> lista las respuestas a ese comentario.
+func (s *postService) GetComments(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	viewer, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	comments, err := s.postRepo.GetComments(viewer, postID, r.URL.Query().Get("parent"), offset, limit)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comments); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *postService) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	if commentID == "" {
+		http.Error(w, "Comment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decodificando el cuerpo de la solicitud: %v", err)
+		http.Error(w, "Cuerpo de solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	content, err := validateCommentContent(req.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	comment, err := s.postRepo.UpdateComment(username, commentID, content)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(comment); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func (s *postService) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	if commentID == "" {
+		http.Error(w, "Comment ID is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.postRepo.DeleteComment(username, commentID); err != nil {
+		writeCommentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModeratePost borra el post de cualquier usuario. Solo para moderadores.
+func (s *postService) ModeratePost(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("id")
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.postRepo.ModeratePost(postID)
+	if err != nil {
+		writePostError(w, "Error borrando el post", err)
+		return
+	}
+	deleteBlobs(r.Context(), s.storage, keys)
+	log.Printf("Moderacion: %s borro el post %s", username, postID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModerateComment borra el comentario de cualquier usuario con sus
+// respuestas. Solo para moderadores.
+func (s *postService) ModerateComment(w http.ResponseWriter, r *http.Request) {
+	commentID := r.PathValue("id")
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.postRepo.ModerateComment(commentID); err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	log.Printf("Moderacion: %s borro el comentario %s", username, commentID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validatePostContent comprueba el contenido de un post nuevo o editado. Un
+// post sin adjuntos debe tener contenido.
+func validatePostContent(content string, hasAttachments bool) error {
+	if len([]rune(content)) > maxPostLength {
+		return fmt.Errorf("el post no puede superar %d caracteres", maxPostLength)
+	}
+	if strings.TrimSpace(content) == "" && !hasAttachments {
+		return Repositories.ErrEmptyPost
+	}
+	return nil
+}
+
+func validateCommentContent(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", errors.New("el comentario no puede estar vacio")
+	}
+	if len([]rune(content)) > maxCommentLength {
+		return "", errors.New("el comentario es demasiado largo")
+	}
+	return content, nil
+}
+
+// validateVisibility comprueba la visibilidad de un post y devuelve la lista
+// de usuarios de visibleTo sin repetidos. visibleTo solo se admite con la
+// visibilidad custom, que necesita al menos un usuario.
+func validateVisibility(visibility string, visibleTo []string) ([]string, error) {
+	if !data.IsValidVisibility(visibility) {
+		return nil, errors.New("visibility debe ser public, friends, friends_of_friends, only_me o custom")
+	}
+
+	var usernames []string
+	for _, username := range visibleTo {
+		username = strings.TrimSpace(username)
+		if username != "" && !containsString(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	if visibility != data.VisibilityCustom {
+		if len(usernames) > 0 {
+			return nil, errors.New("visibleTo solo se usa con visibility custom")
+		}
+		return []string{}, nil
+	}
+	if len(usernames) == 0 {
+		return nil, errors.New("la visibilidad custom necesita al menos un usuario en visibleTo")
+	}
+	if len(usernames) > maxVisibleTo {
+		return nil, fmt.Errorf("visibleTo admite como maximo %d usuarios", maxVisibleTo)
+	}
+	return usernames, nil
+}
+
+// formList lee un campo del formulario que puede venir repetido o separado
+// por comas.
+func formList(r *http.Request, key string) []string {
+	var values []string
+	for _, value := range r.Form[key] {
+		values = append(values, strings.Split(value, ",")...)
+	}
+	return values
+}
+
+func writeCommentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, Repositories.ErrPostNotFound), errors.Is(err, Repositories.ErrCommentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, Repositories.ErrNotCommentAuthor):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Error en comentario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writePostError(w http.ResponseWriter, logMessage string, err error) {
+	switch {
+	case errors.Is(err, Repositories.ErrPostNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, Repositories.ErrNotPostAuthor):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, Repositories.ErrEmptyPost):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: %v", logMessage, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// parsePagination lee los parametros offset y limit de la query.
+func parsePagination(r *http.Request) (offset, limit int, err error) {
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset invalido")
+		}
+	}
+	limit, err = parseLimit(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// parseLimit lee el tamano de pagina de ?limit=, acotado a maxPageSize.
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("limit invalido")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/auth"
+	"SocialMedia/storage"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidatePostContent(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		hasAttachments bool
+		wantErr        bool
+	}{
+		{"con contenido", "hola", false, false},
+		{"vacio sin adjuntos", "  ", false, true},
+		{"vacio con adjuntos", "", true, false},
+		{"en el limite", strings.Repeat("a", maxPostLength), false, false},
+		{"demasiado largo", strings.Repeat("a", maxPostLength+1), true, true},
+		{"limite en runas", strings.Repeat("ñ", maxPostLength), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePostContent(tt.content, tt.hasAttachments)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePostContent = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateVisibility(t *testing.T) {
+	tests := []struct {
+		name       string
+		visibility string
+		visibleTo  []string
+		want       []string
+		wantErr    bool
+	}{
+		{"public", data.VisibilityPublic, nil, []string{}, false},
+		{"friends", data.VisibilityFriends, nil, []string{}, false},
+		{"friends_of_friends", data.VisibilityFriendsOfFriends, nil, []string{}, false},
+		{"only_me", data.VisibilityOnlyMe, nil, []string{}, false},
+		{"custom", data.VisibilityCustom, []string{" bob ", "carol", "bob", ""}, []string{"bob", "carol"}, false},
+		{"custom sin usuarios", data.VisibilityCustom, []string{" "}, nil, true},
+		{"custom con demasiados usuarios", data.VisibilityCustom, manyUsernames(maxVisibleTo + 1), nil, true},
+		{"visibleTo sin custom", data.VisibilityFriends, []string{"bob"}, nil, true},
+		{"desconocida", "everyone", nil, nil, true},
+		{"vacia", "", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateVisibility(tt.visibility, tt.visibleTo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateVisibility = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("validateVisibility = %q, se esperaba %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func manyUsernames(n int) []string {
+	usernames := make([]string, n)
+	for i := range usernames {
+		usernames[i] = fmt.Sprintf("user%d", i)
+	}
+	return usernames
+}
+
+func TestDeletePost(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		postID     string
+		wantStatus int
+	}{
+		{"propio", "alice", "p1", http.StatusOK},
+		{"de otro usuario", "bob", "p1", http.StatusForbidden},
+		{"no existe", "alice", "missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakePostsRepository()
+			bs := storage.NewMemoryStorage("/media")
+			ctx := context.Background()
+			if err := bs.Put(ctx, "posts/p1.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+			post := data.Post{ID: "p1", Media: []data.Media{{Key: "posts/p1.jpg"}}}
+			if err := repo.CreatePost("alice", post); err != nil {
+				t.Fatal(err)
+			}
+			s := NewPostService(repo, nil, bs)
+
+			req := httptest.NewRequest(http.MethodDelete, "/posts/"+tt.postID, nil)
+			req.SetPathValue("id", tt.postID)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: tt.username}))
+			rec := httptest.NewRecorder()
+			s.DeletePost(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, se esperaba %d", rec.Code, tt.wantStatus)
+			}
+			_, err := bs.Get(ctx, "posts/p1.jpg")
+			if deleted := errors.Is(err, storage.ErrNotFound); deleted != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("adjunto borrado = %v, status %d", deleted, rec.Code)
+			}
+		})
+	}
+}
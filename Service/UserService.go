@@ -1,13 +1,24 @@
 package service
 
 import (
+	data "SocialMedia/Data"
 	"SocialMedia/Repositories"
+	"SocialMedia/mail"
+	"SocialMedia/middleware"
+	"SocialMedia/oidc"
+	"SocialMedia/storage"
 	"SocialMedia/utils"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,14 +26,56 @@ import (
 type UserService interface {
 	Register(w http.ResponseWriter, r *http.Request)
 	LoginUser(w http.ResponseWriter, r *http.Request)
+	RefreshToken(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+	GetProfile(w http.ResponseWriter, r *http.Request)
+	UpdateProfile(w http.ResponseWriter, r *http.Request)
+	UploadAvatar(w http.ResponseWriter, r *http.Request)
+	ChangePassword(w http.ResponseWriter, r *http.Request)
+	ChangeEmail(w http.ResponseWriter, r *http.Request)
+	ConfirmEmailChange(w http.ResponseWriter, r *http.Request)
+	DeleteAccount(w http.ResponseWriter, r *http.Request)
+	RestoreAccount(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendVerification(w http.ResponseWriter, r *http.Request)
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	LoginTwoFactor(w http.ResponseWriter, r *http.Request)
+	SetupTwoFactor(w http.ResponseWriter, r *http.Request)
+	ConfirmTwoFactor(w http.ResponseWriter, r *http.Request)
+	DisableTwoFactor(w http.ResponseWriter, r *http.Request)
+	OIDCLogin(w http.ResponseWriter, r *http.Request)
+	OIDCCallback(w http.ResponseWriter, r *http.Request)
+	SetRoles(w http.ResponseWriter, r *http.Request)
+	GetPrivacy(w http.ResponseWriter, r *http.Request)
+	UpdatePrivacy(w http.ResponseWriter, r *http.Request)
 }
 
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxLocationLength    = 50
+	maxWebsiteLength     = 200
+)
+
 type userService struct {
-	userRepo Repositories.UserRepository
+	userRepo  Repositories.UserRepository
+	tokenRepo Repositories.TokenRepository
+	storage   storage.BlobStorage
+	mailer    mail.Mailer
+	limiter   *LoginLimiter
+	providers map[string]*oidc.Provider
 }
 
-func NewUserService(userRepo Repositories.UserRepository) UserService {
-	return &userService{userRepo}
+func NewUserService(userRepo Repositories.UserRepository, tokenRepo Repositories.TokenRepository, bs storage.BlobStorage, mailer mail.Mailer, limiter *LoginLimiter, providers map[string]*oidc.Provider) UserService {
+	return &userService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		storage:   bs,
+		mailer:    mailer,
+		limiter:   limiter,
+		providers: providers,
+	}
 }
 
 func (s *userService) Register(w http.ResponseWriter, r *http.Request) {
@@ -53,7 +106,7 @@ func (s *userService) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.userRepo.CreateUser(user.Username, string(hashedPassword), user.Email); err != nil {
-		if err.Error() == "el username ya está en uso" {
+		if errors.Is(err, Repositories.ErrUsernameTaken) {
 			http.Error(w, err.Error(), http.StatusConflict)
 		} else {
 			log.Printf("Error creando el usuario: %v", err)
@@ -62,15 +115,21 @@ func (s *userService) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	createdUser, err := s.userRepo.GetUser(user.Username)
-	if err != nil {
+	if err != nil || createdUser == nil {
 		log.Printf("Error al obtener el usuario: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	// El registro no falla si no se puede enviar el correo: se puede pedir
+	// otro con /verify-email/resend.
+	if err := s.sendVerificationEmail(r.Context(), createdUser); err != nil {
+		log.Printf("Error enviando la verificacion de email: %v", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(createdUser); err != nil {
+	if err := json.NewEncoder(w).Encode(createdUser.PublicProfile()); err != nil {
 		log.Printf("Error writing response: %v", err)
 	}
 }
@@ -94,6 +153,11 @@ func (s *userService) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	attempt, ok := s.startLoginAttempt(w, credentials.Username, clientIP(r))
+	if !ok {
+		return
+	}
+
 	user, err := s.userRepo.GetUser(credentials.Username)
 	if err != nil {
 		log.Printf("Error al obtener el usuario: %v", err)
@@ -101,34 +165,402 @@ func (s *userService) LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user == nil {
+	reason := "usuario inexistente"
+	if user != nil {
+		reason = "contrasena incorrecta"
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password)); err == nil {
+			reason = ""
+		}
+	}
+	if reason != "" {
+		s.limiter.Failure(r.Context(), attempt, user, reason)
 		http.Error(w, "Usuario o contrasena invalidos", http.StatusUnauthorized)
 		return
 	}
+	if err := s.limiter.Release(attempt); err != nil {
+		log.Printf("Error liberando el intento de inicio de sesion: %v", err)
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user["password"].(string)), []byte(credentials.Password)); err != nil {
-		http.Error(w, "Usuario o contrasena invalidos", http.StatusUnauthorized)
+	// Con la verificacion en dos pasos la contrasena solo da un token para
+	// /login/2fa. Los fallos no se reinician hasta completar el login para
+	// que los codigos no se puedan probar sin limite.
+	if user.TOTPEnabled {
+		response, err := mfaChallenge(user)
+		if err != nil {
+			log.Printf("Error generando el token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeLoginResponse(w, response)
 		return
 	}
 
-	token, err := utils.GenerateToken(credentials.Username)
+	s.completeLogin(w, r, user)
+}
+
+// startLoginAttempt reserva el intento de inicio de sesion antes de probar
+// las credenciales. Si hay que esperar por los intentos fallidos responde
+// 429.
+func (s *userService) startLoginAttempt(w http.ResponseWriter, username, ip string) (*LoginAttempt, bool) {
+	attempt, wait, err := s.limiter.Attempt(username, ip)
+	if err != nil {
+		log.Printf("Error comprobando los intentos de inicio de sesion: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Demasiados intentos fallidos, prueba mas tarde", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return attempt, true
+}
+
+// completeLogin reinicia los intentos fallidos del usuario y responde con un
+// nuevo par de tokens.
+func (s *userService) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	response, err := s.sessionTokens(w, r, user)
 	if err != nil {
 		log.Printf("Error generando el token: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	writeLoginResponse(w, response)
+}
+
+// sessionTokens reinicia los intentos fallidos del usuario y devuelve la
+// respuesta de un login completado, con un nuevo par de tokens. Los tokens
+// tambien se guardan en las cookies de sesion para la web.
+func (s *userService) sessionTokens(w http.ResponseWriter, r *http.Request, user *data.User) (map[string]interface{}, error) {
+	if err := s.limiter.Success(user.Username); err != nil {
+		log.Printf("Error reiniciando los intentos de inicio de sesion: %v", err)
+	}
+
+	tokens, err := utils.GenerateTokenPair(user)
+	if err != nil {
+		return nil, err
+	}
+	middleware.SetSessionCookies(w, r, tokens)
+
+	response := map[string]interface{}{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+		"username":     user.Username,
+	}
+	// La cuenta con el borrado programado puede entrar para restaurarla.
+	if !user.DeleteAfter.IsZero() {
+		response["deleteAfter"] = user.DeleteAfter
+	}
+	return response, nil
+}
+
+// mfaChallenge devuelve la respuesta de un login que falta completar en
+// /login/2fa.
+func mfaChallenge(user *data.User) (map[string]interface{}, error) {
+	mfaToken, err := utils.GenerateActionToken(user.Username, utils.MFAPendingTokenType, "", utils.MFAPendingDuration)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"mfaRequired": true,
+		"mfaToken":    mfaToken,
+		"expiresIn":   int64(utils.MFAPendingDuration.Seconds()),
+	}, nil
+}
 
+func writeLoginResponse(w http.ResponseWriter, response map[string]interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":    token,
-		"username": credentials.Username,
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// RefreshToken intercambia un refresh token valido por un nuevo par de
+// tokens. El refresh token usado queda revocado (rotacion). La web lo envia
+// en la cookie de sesion en vez de en el cuerpo.
+func (s *userService) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	refreshToken, fromCookie, ok := requestRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := utils.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		http.Error(w, "refresh token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := middleware.IsRevoked(s.tokenRepo, claims)
+	if err != nil {
+		log.Printf("Error comprobando la revocacion del token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		http.Error(w, "refresh token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	// Solo una peticion puede revocar el refresh token: si otra lo ha usado a
+	// la vez, esta se rechaza.
+	rotated, err := s.tokenRepo.RevokeToken(claims.Id, claims.ExpiresAtTime())
+	if err != nil {
+		log.Printf("Error revocando el refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !rotated {
+		http.Error(w, "refresh token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	// Los roles se leen de nuevo para que el nuevo access token tenga los
+	// actuales.
+	user, err := s.userRepo.GetUser(claims.Username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "refresh token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := utils.GenerateTokenPair(user)
+	if err != nil {
+		log.Printf("Error generando el token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if fromCookie {
+		middleware.SetSessionCookies(w, r, tokens)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
 		log.Printf("Error writing response: %v", err)
 	}
 }
 
+// Logout revoca el refresh token recibido (en el cuerpo o en la cookie de
+// sesion) y el access token de la peticion, y borra las cookies de sesion.
+func (s *userService) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	refreshToken, _, ok := requestRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	claims, err := utils.ValidateRefreshToken(refreshToken)
+	if err != nil || claims.Username != principal.Username {
+		http.Error(w, "refresh token invalido", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.tokenRepo.RevokeToken(claims.Id, claims.ExpiresAtTime()); err != nil {
+		log.Printf("Error revocando el refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.tokenRepo.RevokeToken(principal.TokenID, principal.TokenExpiresAt); err != nil {
+		log.Printf("Error revocando el access token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	middleware.ClearSessionCookies(w, r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestRefreshToken lee el refresh token del cuerpo ({"refreshToken": ...})
+// o, si no viene, de la cookie de sesion. El cuerpo puede estar vacio.
+func requestRefreshToken(w http.ResponseWriter, r *http.Request) (token string, fromCookie bool, ok bool) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false, false
+	}
+	defer r.Body.Close()
+
+	if req.RefreshToken != "" {
+		return req.RefreshToken, false, true
+	}
+	token, err := middleware.RefreshCookie(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", false, false
+	}
+	return token, token != "", true
+}
+
+// GetProfile devuelve el perfil publico de /users/{username}. "me" es el
+// usuario autenticado. De los perfiles privados solo los amigos ven todos
+// los datos.
+func (s *userService) GetProfile(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	username := r.PathValue("username")
+	if username == "me" {
+		username = viewer
+	}
+
+	profile, err := s.userRepo.GetProfile(viewer, username)
+	if err != nil {
+		log.Printf("Error al obtener el perfil: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// UpdateProfile cambia los campos del perfil incluidos en el cuerpo.
+func (s *userService) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var update data.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := validateProfileUpdate(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	profile, err := s.userRepo.UpdateProfile(username, update)
+	if err != nil {
+		log.Printf("Error actualizando el perfil: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// UploadAvatar sube la imagen del campo "avatar" con el mismo proceso que
+// los adjuntos de los posts y la usa como avatar. Los archivos del avatar
+// anterior se borran.
+func (s *userService) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	if !parseUploadForm(w, r) {
+		return
+	}
+
+	_, header, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "Falta el archivo avatar", http.StatusBadRequest)
+		return
+	}
+
+	fileBytes, media, allowed, err := readMedia(header)
+	if err == nil && !strings.HasPrefix(media.ContentType, "image/") {
+		err = fmt.Errorf("el avatar debe ser una imagen: %w", errUnsupportedMedia)
+	}
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	media, files, err := processMedia(s.storage, "avatars/"+username, fileBytes, media, allowed)
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+
+	keys, err := putFiles(r.Context(), s.storage, files)
+	if err != nil {
+		deleteBlobs(r.Context(), s.storage, keys)
+		writeMediaError(w, err)
+		return
+	}
+
+	avatarURL := media.URL
+	for _, rendition := range media.Renditions {
+		if rendition.Name == "thumbnail" {
+			avatarURL = rendition.URL
+		}
+	}
+
+	oldKeys, err := s.userRepo.SetAvatar(username, avatarURL, keys)
+	if err != nil {
+		deleteBlobs(r.Context(), s.storage, keys)
+		log.Printf("Error guardando el avatar: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	deleteBlobs(r.Context(), s.storage, oldKeys)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"avatarURL": avatarURL}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// validateProfileUpdate recorta los espacios de los campos y comprueba su
+// longitud. La web tiene que ser una URL http o https.
+func validateProfileUpdate(update *data.ProfileUpdate) error {
+	fields := []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"displayName", update.DisplayName, maxDisplayNameLength},
+		{"bio", update.Bio, maxBioLength},
+		{"location", update.Location, maxLocationLength},
+		{"website", update.Website, maxWebsiteLength},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		*field.value = strings.TrimSpace(*field.value)
+		if len([]rune(*field.value)) > field.max {
+			return fmt.Errorf("%s no puede superar los %d caracteres", field.name, field.max)
+		}
+	}
+
+	if update.Website != nil && *update.Website != "" {
+		website, err := url.Parse(*update.Website)
+		if err != nil || (website.Scheme != "http" && website.Scheme != "https") || website.Host == "" {
+			return errors.New("website debe ser una URL http o https")
+		}
+	}
+	return nil
+}
+
 func validateUserData(user struct {
 	Username string `json:"username" validate:"required,alphanum,min=4,max=20"`
 	Password string `json:"password" validate:"required,min=8"`
@@ -139,8 +571,8 @@ func validateUserData(user struct {
 		return errors.New("username invalido")
 	}
 
-	if len(user.Password) < 8 {
-		return errors.New("la contrasena debe tener al menos 8 caracteres")
+	if err := validatePassword(user.Password); err != nil {
+		return err
 	}
 
 	if !isValidEmail(user.Email) {
@@ -166,6 +598,13 @@ func validateLoginCredentials(credentials struct {
 	return nil
 }
 
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("la contrasena debe tener al menos 8 caracteres")
+	}
+	return nil
+}
+
 func isAlphanumeric(str string) bool {
 	pattern := "^[a-zA-Z0-9]*$"
 	regex := regexp.MustCompile(pattern)
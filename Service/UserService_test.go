@@ -0,0 +1,94 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRefreshTestService(t *testing.T) (UserService, *utils.TokenPair) {
+	t.Helper()
+	t.Setenv("JWT", "test-secret")
+
+	user := &data.User{ID: "user-1", Username: "alice", Roles: []string{data.RoleUser}}
+	tokenRepo := Repositories.NewInMemoryTokenRepository()
+	// Sin corte el repositorio en memoria trata al usuario como inexistente.
+	if err := tokenRepo.RevokeUserTokens(user.Username, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewUserService(newFakeUserRepository(user), tokenRepo, nil, nil, nil, nil)
+	tokens, err := utils.GenerateTokenPair(user)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+	return s, tokens
+}
+
+func refresh(s UserService, refreshToken string) int {
+	req := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(`{"refreshToken":"`+refreshToken+`"}`))
+	rec := httptest.NewRecorder()
+	s.RefreshToken(rec, req)
+	return rec.Code
+}
+
+func TestRefreshTokenIsSingleUse(t *testing.T) {
+	s, tokens := newRefreshTestService(t)
+
+	if code := refresh(s, tokens.RefreshToken); code != http.StatusOK {
+		t.Fatalf("primer refresh: status %d, se esperaba 200", code)
+	}
+	if code := refresh(s, tokens.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("segundo refresh: status %d, se esperaba 401", code)
+	}
+}
+
+func TestRefreshTokenConcurrentUse(t *testing.T) {
+	s, tokens := newRefreshTestService(t)
+
+	const requests = 20
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- refresh(s, tokens.RefreshToken)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	ok := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("status inesperado %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d peticiones rotaron el mismo refresh token, se esperaba 1", ok)
+	}
+}
+
+func TestRefreshTokenOfUnknownUser(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+
+	user := &data.User{ID: "user-1", Username: "alice", Roles: []string{data.RoleUser}}
+	s := NewUserService(newFakeUserRepository(user), Repositories.NewInMemoryTokenRepository(), nil, nil, nil, nil)
+	tokens, err := utils.GenerateTokenPair(user)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+	if code := refresh(s, tokens.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("refresh de un usuario inexistente: status %d, se esperaba 401", code)
+	}
+}
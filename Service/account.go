@@ -0,0 +1,312 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/middleware"
+	"SocialMedia/storage"
+	"SocialMedia/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// accountDeletionGracePeriod es el tiempo que tiene el usuario para
+	// deshacer el borrado de su cuenta.
+	accountDeletionGracePeriod = 30 * 24 * time.Hour
+	emailChangeTokenDuration   = 24 * time.Hour
+)
+
+// ChangePassword cambia la contrasena comprobando la actual. Todas las
+// sesiones abiertas se revocan y se devuelve un nuevo par de tokens.
+func (s *userService) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, ok := s.checkPassword(w, r, username, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hasheando la contrasena: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.userRepo.UpdatePassword(username, string(hashedPassword)); err != nil {
+		log.Printf("Error actualizando la contrasena: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.tokenRepo.RevokeUserTokens(username, time.Now()); err != nil {
+		log.Printf("Error revocando las sesiones: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := utils.GenerateTokenPair(user)
+	if err != nil {
+		log.Printf("Error generando el token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// La web sigue con la sesion abierta con los nuevos tokens.
+	if _, err := r.Cookie(middleware.SessionCookieName); err == nil {
+		middleware.SetSessionCookies(w, r, tokens)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// ChangeEmail guarda el nuevo email como pendiente y envia el token para
+// confirmarlo. El email no cambia hasta que se llama a ConfirmEmailChange.
+func (s *userService) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		NewEmail string `json:"newEmail"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, ok := s.checkPassword(w, r, username, req.Password)
+	if !ok {
+		return
+	}
+
+	if !isValidEmail(req.NewEmail) {
+		http.Error(w, "email invalido", http.StatusBadRequest)
+		return
+	}
+	if req.NewEmail == user.Email {
+		http.Error(w, "el email es el mismo que el actual", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.userRepo.SetPendingEmail(username, req.NewEmail); err != nil {
+		log.Printf("Error guardando el email pendiente: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := utils.GenerateActionToken(username, utils.EmailChangeTokenType, req.NewEmail, emailChangeTokenDuration)
+	if err != nil {
+		log.Printf("Error generando el token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.sendEmail(r.Context(), req.NewEmail, emailChangeMessage(user.Username, token)); err != nil {
+		log.Printf("Error enviando la confirmacion del cambio de email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmEmailChange aplica el cambio de email con el token enviado al nuevo
+// email. Solo vale el token del ultimo cambio solicitado.
+func (s *userService) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	claims, err := utils.ValidateActionToken(req.Token, utils.EmailChangeTokenType)
+	if err != nil {
+		http.Error(w, "token invalido", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.userRepo.ConfirmEmail(claims.Username, claims.Value); err != nil {
+		if errors.Is(err, Repositories.ErrNoPendingEmail) {
+			http.Error(w, "token invalido", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error confirmando el email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAccount programa el borrado de la cuenta tras
+// accountDeletionGracePeriod y cierra todas sus sesiones. Mientras tanto el
+// perfil queda oculto y el usuario puede volver a iniciar sesion y llamar a
+// RestoreAccount.
+func (s *userService) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.checkPassword(w, r, username, req.Password); !ok {
+		return
+	}
+
+	deleteAfter := time.Now().Add(accountDeletionGracePeriod)
+	if err := s.userRepo.ScheduleDeletion(username, deleteAfter); err != nil {
+		log.Printf("Error programando el borrado de la cuenta: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.tokenRepo.RevokeUserTokens(username, time.Now()); err != nil {
+		log.Printf("Error revocando las sesiones: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"deleteAfter": deleteAfter}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// RestoreAccount cancela el borrado programado de la cuenta.
+func (s *userService) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if err := s.userRepo.CancelDeletion(username); err != nil {
+		if errors.Is(err, Repositories.ErrNoPendingDelete) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Error cancelando el borrado de la cuenta: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPassword comprueba la contrasena del usuario autenticado antes de una
+// operacion sensible. Pasa por el LoginLimiter como un inicio de sesion, para
+// que quien tenga un token robado no pueda probar contrasenas sin limite. Si
+// no coincide escribe la respuesta y devuelve false.
+func (s *userService) checkPassword(w http.ResponseWriter, r *http.Request, username, password string) (*data.User, bool) {
+	attempt, ok := s.startLoginAttempt(w, username, clientIP(r))
+	if !ok {
+		return nil, false
+	}
+
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return nil, false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		s.limiter.Failure(r.Context(), attempt, user, "contrasena incorrecta")
+		http.Error(w, "contrasena incorrecta", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err := s.limiter.Release(attempt); err != nil {
+		log.Printf("Error liberando el intento de inicio de sesion: %v", err)
+	}
+	return user, true
+}
+
+// AccountPurger borra definitivamente las cuentas cuyo periodo para deshacer
+// el borrado ha terminado, junto con sus archivos del storage.
+type AccountPurger struct {
+	userRepo Repositories.UserRepository
+	storage  storage.BlobStorage
+}
+
+func NewAccountPurger(userRepo Repositories.UserRepository, bs storage.BlobStorage) *AccountPurger {
+	return &AccountPurger{userRepo: userRepo, storage: bs}
+}
+
+// Run ejecuta Purge cada interval hasta que se cancela ctx.
+func (p *AccountPurger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Purge(ctx); err != nil {
+			log.Printf("Error borrando cuentas: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *AccountPurger) Purge(ctx context.Context) error {
+	usernames, err := p.userRepo.GetUsersToDelete(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, username := range usernames {
+		keys, err := p.userRepo.DeleteUser(username)
+		if err != nil {
+			log.Printf("Error borrando la cuenta de %s: %v", username, err)
+			continue
+		}
+		deleteBlobs(ctx, p.storage, keys)
+		log.Printf("Cuenta de %s borrada", username)
+	}
+	return nil
+}
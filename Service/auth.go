@@ -0,0 +1,26 @@
+package service
+
+import (
+	"SocialMedia/auth"
+	"net/http"
+)
+
+// currentPrincipal devuelve el usuario autenticado de la peticion. Si la ruta
+// no paso por el middleware de autenticacion responde 401.
+func currentPrincipal(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "no autenticado", http.StatusUnauthorized)
+		return nil, false
+	}
+	return principal, true
+}
+
+// currentUsername es currentPrincipal cuando solo hace falta el username.
+func currentUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	principal, ok := currentPrincipal(w, r)
+	if !ok {
+		return "", false
+	}
+	return principal.Username, true
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// BlockUser bloquea al usuario del cuerpo. Se borra la amistad o solicitud
+// que hubiera y ninguno de los dos vuelve a ver los posts, comentarios ni
+// reacciones del otro.
+func (s *friendsService) BlockUser(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.BlockUser(username, other); err != nil {
+		writeFriendError(w, "Error blocking user", err)
+		return
+	}
+
+	writeFriendMessage(w, "User blocked")
+}
+
+func (s *friendsService) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.UnblockUser(username, other); err != nil {
+		writeFriendError(w, "Error unblocking user", err)
+		return
+	}
+
+	writeFriendMessage(w, "User unblocked")
+}
+
+func (s *friendsService) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	users, err := s.FriendRepo.GetBlockedUsers(username)
+	if err != nil {
+		log.Printf("Error getting blocked users: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeRestrictedUsers(w, users)
+}
+
+// MuteUser silencia al usuario del cuerpo: sus posts dejan de salir en el
+// feed, pero se siguen viendo en su perfil.
+func (s *friendsService) MuteUser(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.MuteUser(username, other); err != nil {
+		writeFriendError(w, "Error muting user", err)
+		return
+	}
+
+	writeFriendMessage(w, "User muted")
+}
+
+func (s *friendsService) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	username, other, ok := decodeFriendRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.FriendRepo.UnmuteUser(username, other); err != nil {
+		writeFriendError(w, "Error unmuting user", err)
+		return
+	}
+
+	writeFriendMessage(w, "User unmuted")
+}
+
+func (s *friendsService) GetMutedUsers(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	users, err := s.FriendRepo.GetMutedUsers(username)
+	if err != nil {
+		log.Printf("Error getting muted users: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeRestrictedUsers(w, users)
+}
+
+func writeRestrictedUsers(w http.ResponseWriter, users []data.RestrictedUser) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
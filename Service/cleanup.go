@@ -0,0 +1,52 @@
+package service
+
+import (
+	"SocialMedia/Repositories"
+	"context"
+	"log"
+	"time"
+)
+
+// auditRetention es cuanto se guardan los eventos de auditoria.
+const auditRetention = 90 * 24 * time.Hour
+
+// Cleanup borra periodicamente los datos que ya no hacen falta, para no
+// hacerlo en cada peticion.
+type Cleanup struct {
+	tokenRepo        Repositories.TokenRepository
+	loginAttemptRepo Repositories.LoginAttemptRepository
+}
+
+func NewCleanup(tokenRepo Repositories.TokenRepository, loginAttemptRepo Repositories.LoginAttemptRepository) *Cleanup {
+	return &Cleanup{tokenRepo: tokenRepo, loginAttemptRepo: loginAttemptRepo}
+}
+
+// Run ejecuta Clean cada interval hasta que se cancela ctx.
+func (c *Cleanup) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Clean(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Clean borra los tokens revocados que ya han caducado, los contadores de
+// inicios de sesion fallidos olvidados y los eventos de auditoria de hace
+// mas de auditRetention.
+func (c *Cleanup) Clean(now time.Time) {
+	if err := c.tokenRepo.DeleteExpiredTokens(now); err != nil {
+		log.Printf("Error borrando los tokens caducados: %v", err)
+	}
+	if err := c.loginAttemptRepo.DeleteStaleAttempts(now.Add(-loginFailureWindow)); err != nil {
+		log.Printf("Error borrando los intentos de inicio de sesion: %v", err)
+	}
+	if err := c.loginAttemptRepo.DeleteAuditEvents(now.Add(-auditRetention)); err != nil {
+		log.Printf("Error borrando los eventos de auditoria: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"sync"
+)
+
+// fakeUserRepository implementa solo los metodos de UserRepository que usan
+// los tests; el resto provocan un panic por la interfaz embebida a nil.
+type fakeUserRepository struct {
+	Repositories.UserRepository
+
+	mu         sync.Mutex
+	users      map[string]*data.User
+	identities map[string]string
+	// totpCounters es el ultimo periodo TOTP aceptado de cada usuario.
+	totpCounters map[string]int64
+	// beforeCreate se llama antes de crear un usuario, para simular
+	// peticiones simultaneas.
+	beforeCreate func()
+}
+
+func newFakeUserRepository(users ...*data.User) *fakeUserRepository {
+	repo := &fakeUserRepository{
+		users:        make(map[string]*data.User),
+		identities:   make(map[string]string),
+		totpCounters: make(map[string]int64),
+	}
+	for _, user := range users {
+		repo.users[user.Username] = user
+	}
+	return repo
+}
+
+func (r *fakeUserRepository) GetUser(username string) (*data.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepository) GetUsersByEmail(email string) ([]*data.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []*data.User
+	for _, user := range r.users {
+		if user.Email == email {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (r *fakeUserRepository) UpdatePassword(username, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[username]; ok {
+		user.PasswordHash = passwordHash
+	}
+	return nil
+}
+
+func (r *fakeUserRepository) GetUserByIdentity(provider, subject string) (*data.User, error) {
+	r.mu.Lock()
+	username, ok := r.identities[provider+"|"+subject]
+	r.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return r.GetUser(username)
+}
+
+func (r *fakeUserRepository) CreateUserWithIdentity(username, password, email string, emailVerified bool, provider, subject string) error {
+	if r.beforeCreate != nil {
+		r.beforeCreate()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[username]; ok {
+		return Repositories.ErrUsernameTaken
+	}
+	if _, ok := r.identities[provider+"|"+subject]; ok {
+		return Repositories.ErrIdentityLinked
+	}
+	r.users[username] = &data.User{Username: username, PasswordHash: password, Email: email, EmailVerified: emailVerified}
+	r.identities[provider+"|"+subject] = username
+	return nil
+}
+
+func (r *fakeUserRepository) SetRoles(username string, roles []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return Repositories.ErrUserNotFound
+	}
+	if data.HasRole(user.Roles, data.RoleAdmin) && !containsString(roles, data.RoleAdmin) {
+		admins := 0
+		for _, u := range r.users {
+			if containsString(u.Roles, data.RoleAdmin) {
+				admins++
+			}
+		}
+		if admins <= 1 {
+			return Repositories.ErrLastAdmin
+		}
+	}
+	user.Roles = roles
+	return nil
+}
+
+func (r *fakeUserRepository) AddRole(username, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return Repositories.ErrUserNotFound
+	}
+	if !containsString(user.Roles, role) {
+		user.Roles = append(user.Roles, role)
+	}
+	return nil
+}
+
+func (r *fakeUserRepository) UpdatePrivacy(username string, settings data.PrivacySettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return Repositories.ErrUserNotFound
+	}
+	user.Privacy = settings
+	return nil
+}
+
+func (r *fakeUserRepository) EnableTOTP(username, secret string, counter int64, recoveryCodes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[username]
+	if !ok || user.TOTPPendingSecret != secret {
+		return Repositories.ErrNoPendingTOTP
+	}
+	user.TOTPEnabled = true
+	user.TOTPSecret = secret
+	user.TOTPPendingSecret = ""
+	user.RecoveryCodes = recoveryCodes
+	r.totpCounters[username] = counter
+	return nil
+}
+
+func (r *fakeUserRepository) UseTOTPCounter(username string, counter int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if last, ok := r.totpCounters[username]; ok && last >= counter {
+		return Repositories.ErrTOTPCodeUsed
+	}
+	r.totpCounters[username] = counter
+	return nil
+}
+
+// fakePostsRepository guarda los posts en memoria, con los mismos errores que
+// el repositorio de Neo4j.
+type fakePostsRepository struct {
+	Repositories.PostsRepository
+
+	mu      sync.Mutex
+	posts   map[string]data.Post
+	authors map[string]string
+}
+
+func newFakePostsRepository() *fakePostsRepository {
+	return &fakePostsRepository{posts: make(map[string]data.Post), authors: make(map[string]string)}
+}
+
+func (r *fakePostsRepository) CreatePost(username string, post data.Post) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	post.Author = username
+	r.posts[post.ID] = post
+	r.authors[post.ID] = username
+	return nil
+}
+
+func (r *fakePostsRepository) DeletePost(username, postID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	post, ok := r.posts[postID]
+	if !ok {
+		return nil, Repositories.ErrPostNotFound
+	}
+	if r.authors[postID] != username {
+		return nil, Repositories.ErrNotPostAuthor
+	}
+	delete(r.posts, postID)
+	delete(r.authors, postID)
+
+	var keys []string
+	for _, media := range post.Media {
+		keys = append(keys, media.Key)
+		for _, rendition := range media.Renditions {
+			keys = append(keys, rendition.Key)
+		}
+	}
+	return keys, nil
+}
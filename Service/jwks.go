@@ -0,0 +1,18 @@
+package service
+
+import (
+	"SocialMedia/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// JWKS publica las claves publicas con las que otros servicios pueden
+// verificar los tokens emitidos aqui.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(utils.PublicKeys()); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
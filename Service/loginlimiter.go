@@ -0,0 +1,198 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/mail"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	loginBaseDelay       = time.Second
+	loginLockoutDuration = 15 * time.Minute
+	loginMaxLockout      = time.Hour
+	// loginFailureWindow es cuanto se recuerdan los fallos seguidos.
+	loginFailureWindow = 24 * time.Hour
+)
+
+// loginPolicy define cuantos fallos seguidos se permiten en una clave antes
+// de tener que esperar y antes de bloquearla.
+type loginPolicy struct {
+	prefix       string
+	freeAttempts int
+	lockoutAfter int
+}
+
+// Una IP puede ser compartida por muchos usuarios (NAT), asi que tolera mas
+// fallos que un usuario.
+var (
+	usernameLoginPolicy = loginPolicy{prefix: "user:", freeAttempts: 3, lockoutAfter: 5}
+	ipLoginPolicy       = loginPolicy{prefix: "ip:", freeAttempts: 10, lockoutAfter: 20}
+)
+
+// delay devuelve cuanto hay que esperar despues de failures fallos seguidos.
+// Hasta lockoutAfter la espera se duplica desde loginBaseDelay; a partir de
+// ahi la clave queda bloqueada desde loginLockoutDuration, duplicando hasta
+// loginMaxLockout.
+func (p loginPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures < p.freeAttempts:
+		return 0
+	case failures < p.lockoutAfter:
+		return loginBaseDelay << (failures - p.freeAttempts)
+	case failures-p.lockoutAfter >= 3:
+		return loginMaxLockout
+	default:
+		return min(loginLockoutDuration<<(failures-p.lockoutAfter), loginMaxLockout)
+	}
+}
+
+// LockoutNotifier recibe los bloqueos de cuentas por demasiados inicios de
+// sesion fallidos.
+type LockoutNotifier interface {
+	NotifyLockout(ctx context.Context, user *data.User, until time.Time) error
+}
+
+type mailLockoutNotifier struct {
+	mailer mail.Mailer
+}
+
+// NewMailLockoutNotifier avisa por email al usuario de que su cuenta se ha
+// bloqueado.
+func NewMailLockoutNotifier(mailer mail.Mailer) LockoutNotifier {
+	return &mailLockoutNotifier{mailer}
+}
+
+func (n *mailLockoutNotifier) NotifyLockout(ctx context.Context, user *data.User, until time.Time) error {
+	return n.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Tu cuenta se ha bloqueado temporalmente",
+		Body: fmt.Sprintf("Hola %s,\n\nHemos bloqueado el inicio de sesion en tu cuenta hasta las %s por demasiados intentos fallidos.\n\nSi no has sido tu, te recomendamos cambiar tu contrasena.\n",
+			user.Username, until.Format("15:04 (MST) del 02/01/2006")),
+	})
+}
+
+// LoginLimiter limita los inicios de sesion fallidos por usuario y por IP
+// con espera exponencial y bloqueo temporal.
+type LoginLimiter struct {
+	repo     Repositories.LoginAttemptRepository
+	notifier LockoutNotifier
+}
+
+// NewLoginLimiter crea un LoginLimiter. notifier puede ser nil.
+func NewLoginLimiter(repo Repositories.LoginAttemptRepository, notifier LockoutNotifier) *LoginLimiter {
+	return &LoginLimiter{repo: repo, notifier: notifier}
+}
+
+// LoginAttempt es un intento de inicio de sesion reservado con Attempt.
+// Cuenta como fallido hasta que se llama a Release o a Success.
+type LoginAttempt struct {
+	Username string
+	IP       string
+	// failures son los fallos seguidos del usuario contando este intento.
+	failures int
+	// at es cuando se reservo, para que Release deje el ultimo fallo como
+	// estaba.
+	at time.Time
+}
+
+// Attempt reserva un intento de iniciar sesion como username desde ip antes
+// de comprobar las credenciales, contandolo ya como fallido. Si hay que
+// esperar no se reserva nada y devuelve cuanto falta. Asi varias peticiones
+// simultaneas no pueden pasar todas la comprobacion antes de que se
+// registren sus fallos.
+func (l *LoginLimiter) Attempt(username, ip string) (*LoginAttempt, time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-loginFailureWindow)
+
+	failures, wait, err := l.repo.ReserveAttempt(usernameLoginPolicy.prefix+username, now, since, usernameLoginPolicy.delay)
+	if err != nil || wait > 0 {
+		if wait > 0 {
+			l.audit(data.AuditEvent{Type: data.AuditLoginBlocked, Username: username, IP: ip})
+		}
+		return nil, wait, err
+	}
+
+	_, wait, err = l.repo.ReserveAttempt(ipLoginPolicy.prefix+ip, now, since, ipLoginPolicy.delay)
+	if err != nil || wait > 0 {
+		if releaseErr := l.repo.ReleaseAttempt(usernameLoginPolicy.prefix+username, now); releaseErr != nil {
+			log.Printf("Error liberando el intento de inicio de sesion de %s: %v", username, releaseErr)
+		}
+		if wait > 0 {
+			l.audit(data.AuditEvent{Type: data.AuditLoginBlocked, Username: username, IP: ip})
+		}
+		return nil, wait, err
+	}
+
+	return &LoginAttempt{Username: username, IP: ip, failures: failures, at: now}, 0, nil
+}
+
+// Release descuenta un intento cuyas credenciales eran correctas. Los
+// fallos anteriores se mantienen hasta Success, que se llama al completar
+// el login (tras la verificacion en dos pasos si la tiene).
+func (l *LoginLimiter) Release(attempt *LoginAttempt) error {
+	if err := l.repo.ReleaseAttempt(usernameLoginPolicy.prefix+attempt.Username, attempt.at); err != nil {
+		return err
+	}
+	return l.repo.ReleaseAttempt(ipLoginPolicy.prefix+attempt.IP, attempt.at)
+}
+
+// Failure registra que el intento ha fallado, que ya estaba contado. user es
+// nil si el usuario no existe. Si la cuenta queda bloqueada se avisa al
+// notifier.
+func (l *LoginLimiter) Failure(ctx context.Context, attempt *LoginAttempt, user *data.User, reason string) {
+	l.audit(data.AuditEvent{Type: data.AuditLoginFailed, Username: attempt.Username, IP: attempt.IP, Reason: reason})
+
+	if attempt.failures != usernameLoginPolicy.lockoutAfter {
+		return
+	}
+	l.audit(data.AuditEvent{Type: data.AuditAccountLocked, Username: attempt.Username, IP: attempt.IP})
+	if user != nil && l.notifier != nil {
+		until := time.Now().Add(usernameLoginPolicy.delay(attempt.failures))
+		if err := l.notifier.NotifyLockout(ctx, user, until); err != nil {
+			log.Printf("Error avisando del bloqueo de %s: %v", attempt.Username, err)
+		}
+	}
+}
+
+// Success olvida los fallos del usuario. Los de la IP se mantienen para que
+// entrar en una cuenta propia no sirva para seguir probando otras.
+func (l *LoginLimiter) Success(username string) error {
+	return l.repo.ResetFailures(usernameLoginPolicy.prefix + username)
+}
+
+func (l *LoginLimiter) audit(event data.AuditEvent) {
+	event.CreatedAt = time.Now()
+	log.Printf("Auditoria: %s usuario=%q ip=%s %s", event.Type, event.Username, event.IP, event.Reason)
+	if err := l.repo.AddAuditEvent(event); err != nil {
+		log.Printf("Error guardando el evento de auditoria: %v", err)
+	}
+}
+
+// clientIP devuelve la IP del cliente. Por defecto es la de la conexion,
+// porque las cabeceras las puede falsificar el cliente. Detras de un proxy
+// se puede indicar en TRUSTED_PROXY_HEADER la cabecera que este rellena
+// (por ejemplo X-Real-IP o X-Forwarded-For); se usa la ultima IP de la
+// cabecera, que es la que anade el proxy. El proxy debe ser la unica forma
+// de llegar al servidor.
+func clientIP(r *http.Request) string {
+	if header := os.Getenv("TRUSTED_PROXY_HEADER"); header != "" {
+		values := strings.Split(r.Header.Get(header), ",")
+		if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
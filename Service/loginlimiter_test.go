@@ -0,0 +1,184 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/auth"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type recordingNotifier struct {
+	mu      sync.Mutex
+	lockout []string
+}
+
+func (n *recordingNotifier) NotifyLockout(ctx context.Context, user *data.User, until time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lockout = append(n.lockout, user.Username)
+	return nil
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, loginBaseDelay},
+		{4, 2 * loginBaseDelay},
+		{5, loginLockoutDuration},
+		{6, 2 * loginLockoutDuration},
+		{8, loginMaxLockout},
+		{100, loginMaxLockout},
+	}
+	for _, tt := range tests {
+		if got := usernameLoginPolicy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, se esperaba %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// Los intentos simultaneos se reservan antes de comprobar la contrasena, asi
+// que solo pasan los intentos gratis aunque ninguno haya fallado todavia.
+func TestLoginLimiterConcurrentAttempts(t *testing.T) {
+	limiter := NewLoginLimiter(Repositories.NewInMemoryLoginAttemptRepository(), nil)
+
+	const requests = 20
+	var wg sync.WaitGroup
+	results := make(chan bool, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, wait, err := limiter.Attempt("alice", "10.0.0.1")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- attempt != nil && wait == 0
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	passed := 0
+	for ok := range results {
+		if ok {
+			passed++
+		}
+	}
+	if passed != usernameLoginPolicy.freeAttempts {
+		t.Fatalf("pasaron %d intentos simultaneos, se esperaban %d", passed, usernameLoginPolicy.freeAttempts)
+	}
+}
+
+func TestLoginLimiterReleaseAndLockout(t *testing.T) {
+	notifier := &recordingNotifier{}
+	limiter := NewLoginLimiter(Repositories.NewInMemoryLoginAttemptRepository(), notifier)
+	user := &data.User{Username: "alice", Email: "alice@example.com"}
+
+	// Las contrasenas correctas no cuentan como fallos.
+	for i := 0; i < 10; i++ {
+		attempt, wait, err := limiter.Attempt("alice", "10.0.0.1")
+		if err != nil || wait > 0 {
+			t.Fatalf("intento correcto %d: wait %v, err %v", i, wait, err)
+		}
+		if err := limiter.Release(attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < usernameLoginPolicy.freeAttempts; i++ {
+		attempt, wait, err := limiter.Attempt("alice", "10.0.0.1")
+		if err != nil || wait > 0 {
+			t.Fatalf("intento fallido %d: wait %v, err %v", i, wait, err)
+		}
+		limiter.Failure(context.Background(), attempt, user, "contrasena incorrecta")
+	}
+	if _, wait, _ := limiter.Attempt("alice", "10.0.0.1"); wait <= 0 {
+		t.Fatal("tras los intentos gratis hay que esperar")
+	}
+
+	// Otro usuario desde la misma IP no espera: la IP tolera mas fallos.
+	if _, wait, _ := limiter.Attempt("bob", "10.0.0.1"); wait > 0 {
+		t.Fatalf("bob tiene que esperar %v", wait)
+	}
+	if len(notifier.lockout) != 0 {
+		t.Fatalf("avisos de bloqueo = %v, no se esperaba ninguno", notifier.lockout)
+	}
+
+	// Al llegar a lockoutAfter fallos se avisa una vez.
+	limiter.Failure(context.Background(), &LoginAttempt{Username: "alice", IP: "10.0.0.1", failures: usernameLoginPolicy.lockoutAfter}, user, "contrasena incorrecta")
+	if len(notifier.lockout) != 1 {
+		t.Fatalf("avisos de bloqueo = %v, se esperaba uno", notifier.lockout)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name          string
+		trustedHeader string
+		header        string
+		value         string
+		want          string
+	}{
+		{name: "sin proxy se ignoran las cabeceras", header: "X-Forwarded-For", value: "1.2.3.4", want: "192.0.2.1"},
+		{name: "X-Real-IP de confianza", trustedHeader: "X-Real-IP", header: "X-Real-IP", value: "1.2.3.4", want: "1.2.3.4"},
+		{name: "X-Forwarded-For usa la ultima IP", trustedHeader: "X-Forwarded-For", header: "X-Forwarded-For", value: "6.6.6.6, 1.2.3.4", want: "1.2.3.4"},
+		{name: "cabecera de confianza ausente", trustedHeader: "X-Real-IP", want: "192.0.2.1"},
+		{name: "cabecera de confianza invalida", trustedHeader: "X-Real-IP", header: "X-Real-IP", value: "no-es-una-ip", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXY_HEADER", tt.trustedHeader)
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, se esperaba %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Las operaciones que piden la contrasena actual cuentan los fallos igual que
+// el login, para que un token robado no permita probar contrasenas sin limite.
+func TestCheckPasswordIsRateLimited(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newFakeUserRepository(&data.User{Username: "alice", PasswordHash: string(hash)})
+	limiter := NewLoginLimiter(Repositories.NewInMemoryLoginAttemptRepository(), nil)
+	s := NewUserService(repo, Repositories.NewInMemoryTokenRepository(), nil, nil, limiter, nil)
+
+	changePassword := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/password",
+			strings.NewReader(`{"currentPassword":"wrong-password","newPassword":"new-password-123"}`))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: "alice"}))
+		rec := httptest.NewRecorder()
+		s.ChangePassword(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < usernameLoginPolicy.freeAttempts; i++ {
+		if code := changePassword(); code != http.StatusUnauthorized {
+			t.Fatalf("intento %d: status %d, se esperaba %d", i, code, http.StatusUnauthorized)
+		}
+	}
+	if code := changePassword(); code != http.StatusTooManyRequests {
+		t.Fatalf("tras los intentos gratis: status %d, se esperaba %d", code, http.StatusTooManyRequests)
+	}
+}
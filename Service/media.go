@@ -0,0 +1,279 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/middleware"
+	"SocialMedia/storage"
+	"SocialMedia/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxAttachments = 10
+	// maxPostUploadSize limita el cuerpo completo de una subida, con todos
+	// sus adjuntos.
+	maxPostUploadSize = 100 << 20
+)
+
+var (
+	errUnsupportedMedia = errors.New("tipo de archivo no permitido: solo imagenes JPEG, PNG, GIF o WebP y videos MP4 o WebM")
+	errMediaTooLarge    = errors.New("el archivo supera el tamano maximo permitido")
+)
+
+type mediaType struct {
+	maxSize   int64
+	extension string
+}
+
+// allowedMediaTypes es la lista de tipos de archivo permitidos en los
+// adjuntos, segun el tipo detectado a partir del contenido.
+var allowedMediaTypes = map[string]mediaType{
+	"image/jpeg": {maxSize: 10 << 20, extension: ".jpg"},
+	"image/png":  {maxSize: 10 << 20, extension: ".png"},
+	"image/gif":  {maxSize: 5 << 20, extension: ".gif"},
+	"image/webp": {maxSize: 10 << 20, extension: ".webp"},
+	"video/mp4":  {maxSize: 50 << 20, extension: ".mp4"},
+	"video/webm": {maxSize: 50 << 20, extension: ".webm"},
+}
+
+// parseUploadForm parsea un formulario multipart de como mucho
+// maxPostUploadSize bytes. Si falla responde al cliente y devuelve false.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPostUploadSize)
+	err := r.ParseMultipartForm(10 << 20)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("La subida supera el maximo de %d MB", maxPostUploadSize>>20), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	log.Printf("Error al parsear el formulario multipart: %v", err)
+	http.Error(w, "Error al procesar la carga del archivo", http.StatusBadRequest)
+	return false
+}
+
+// postAttachments devuelve los archivos adjuntos del formulario en el orden
+// en que se enviaron. Se aceptan varios en "files" y uno en "file", que es
+// el campo que usaban los clientes antes de permitir varios adjuntos.
+func postAttachments(form *multipart.Form) []*multipart.FileHeader {
+	if form == nil {
+		return nil
+	}
+	var headers []*multipart.FileHeader
+	headers = append(headers, form.File["file"]...)
+	headers = append(headers, form.File["files"]...)
+	return headers
+}
+
+// readMedia lee un adjunto y devuelve su contenido junto con los datos del
+// Media, sin URL ni key. El tipo se detecta a partir del contenido, sin
+// fiarse del Content-Type ni del nombre que envia el cliente.
+func readMedia(header *multipart.FileHeader) ([]byte, data.Media, mediaType, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, data.Media{}, mediaType{}, err
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		return nil, data.Media{}, mediaType{}, err
+	}
+
+	contentType := http.DetectContentType(fileBytes)
+	allowed, ok := allowedMediaTypes[contentType]
+	if !ok {
+		return nil, data.Media{}, mediaType{}, fmt.Errorf("%s: %w", header.Filename, errUnsupportedMedia)
+	}
+	if int64(len(fileBytes)) > allowed.maxSize {
+		return nil, data.Media{}, mediaType{}, fmt.Errorf("%s (%s, maximo %d MB): %w",
+			header.Filename, contentType, allowed.maxSize>>20, errMediaTooLarge)
+	}
+
+	media := data.Media{
+		ID:          uuid.New().String(),
+		ContentType: contentType,
+		Size:        int64(len(fileBytes)),
+	}
+
+	return fileBytes, media, allowed, nil
+}
+
+// processedFile es un archivo pendiente de subir al storage.
+type processedFile struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+// processMedia pasa las imagenes por utils.ProcessImage (orientacion, sin
+// metadatos y versiones reducidas) y devuelve los archivos a subir con el
+// Media ya completo. Los videos se suben tal cual.
+// Las keys empiezan por keyPrefix (por ejemplo "posts/<id>").
+func processMedia(bs storage.BlobStorage, keyPrefix string, fileBytes []byte, media data.Media, allowed mediaType) (data.Media, []processedFile, error) {
+	media.Key = fmt.Sprintf("%s/%s%s", keyPrefix, media.ID, allowed.extension)
+	media.URL = bs.URL(media.Key)
+	if !strings.HasPrefix(media.ContentType, "image/") {
+		return media, []processedFile{{key: media.Key, data: fileBytes, contentType: media.ContentType}}, nil
+	}
+
+	processed, err := utils.ProcessImage(fileBytes, media.ContentType)
+	switch {
+	case errors.Is(err, utils.ErrImageTooLarge):
+		return media, nil, fmt.Errorf("%v: %w", err, errMediaTooLarge)
+	case errors.Is(err, utils.ErrInvalidImage):
+		return media, nil, fmt.Errorf("%v: %w", err, errUnsupportedMedia)
+	case err != nil:
+		return media, nil, err
+	}
+
+	media.Size = int64(len(processed.Data))
+	media.Width, media.Height = processed.Width, processed.Height
+	files := []processedFile{{key: media.Key, data: processed.Data, contentType: processed.ContentType}}
+
+	for _, rendition := range processed.Renditions {
+		key := fmt.Sprintf("%s/%s-%s%s", keyPrefix, media.ID, rendition.Name, imageExtension(rendition.ContentType))
+		media.Renditions = append(media.Renditions, data.Rendition{
+			Name:   rendition.Name,
+			Key:    key,
+			URL:    bs.URL(key),
+			Width:  rendition.Width,
+			Height: rendition.Height,
+		})
+		files = append(files, processedFile{key: key, data: rendition.Data, contentType: rendition.ContentType})
+	}
+	return media, files, nil
+}
+
+func imageExtension(contentType string) string {
+	if allowed, ok := allowedMediaTypes[contentType]; ok {
+		return allowed.extension
+	}
+	return ""
+}
+
+// uploadMedia valida, procesa y sube los adjuntos de un post. Las keys se
+// generan a partir de keyPrefix y del ID del adjunto, nunca del nombre del
+// archivo, para que dos usuarios no puedan pisarse los archivos. Si algun
+// adjunto falla se borran los que ya se habian subido.
+func uploadMedia(ctx context.Context, bs storage.BlobStorage, keyPrefix string, headers []*multipart.FileHeader) ([]data.Media, error) {
+	uploaded := []data.Media{}
+	var uploadedKeys []string
+	for _, header := range headers {
+		fileBytes, media, allowed, err := readMedia(header)
+		if err != nil {
+			deleteBlobs(ctx, bs, uploadedKeys)
+			return nil, err
+		}
+
+		media, files, err := processMedia(bs, keyPrefix, fileBytes, media, allowed)
+		if err != nil {
+			deleteBlobs(ctx, bs, uploadedKeys)
+			return nil, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+
+		keys, err := putFiles(ctx, bs, files)
+		uploadedKeys = append(uploadedKeys, keys...)
+		if err != nil {
+			deleteBlobs(ctx, bs, uploadedKeys)
+			return nil, err
+		}
+		uploaded = append(uploaded, media)
+	}
+	return uploaded, nil
+}
+
+// putFiles sube los archivos y devuelve las keys de los que se subieron,
+// tambien cuando alguno falla.
+func putFiles(ctx context.Context, bs storage.BlobStorage, files []processedFile) ([]string, error) {
+	var keys []string
+	for _, file := range files {
+		if err := bs.Put(ctx, file.key, file.data, file.contentType); err != nil {
+			return keys, err
+		}
+		keys = append(keys, file.key)
+	}
+	return keys, nil
+}
+
+// mediaKeys devuelve las keys de los adjuntos y de todas sus versiones.
+func mediaKeys(media []data.Media) []string {
+	keys := make([]string, 0, len(media))
+	for _, m := range media {
+		keys = append(keys, m.Key)
+		for _, rendition := range m.Renditions {
+			keys = append(keys, rendition.Key)
+		}
+	}
+	return keys
+}
+
+// deleteBlobs borra los blobs indicados. Los errores solo se registran: un
+// blob huerfano no debe hacer fallar la peticion.
+func deleteBlobs(ctx context.Context, bs storage.BlobStorage, keys []string) {
+	for _, key := range keys {
+		if err := bs.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error borrando el blob %s: %v", key, err)
+		}
+	}
+}
+
+// writeMediaError responde 415 o 413 a los adjuntos rechazados y 500 al
+// resto de errores.
+func writeMediaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedMedia):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, errMediaTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		log.Printf("Error al subir el archivo a Blob Storage: %v", err)
+		http.Error(w, "Error al subir el archivo", http.StatusInternalServerError)
+	}
+}
+
+// MediaHandler sirve los archivos de un storage que no tiene servidor propio
+// (local y memory). Los adjuntos de los posts, con keys posts/<id>/..., solo
+// los descarga quien puede ver el post; los avatares son publicos.
+func MediaHandler(prefix string, files http.Handler, postRepo Repositories.PostsRepository) http.Handler {
+	postMedia := middleware.Scoped(data.ScopePostsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer, ok := currentUsername(w, r)
+		if !ok {
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, prefix)
+		postID, _, _ := strings.Cut(strings.TrimPrefix(key, "posts/"), "/")
+		if err := postRepo.CheckPostVisible(viewer, postID); err != nil {
+			if errors.Is(err, Repositories.ErrPostNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("Error comprobando el acceso al adjunto: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "private")
+		files.ServeHTTP(w, r)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(strings.TrimPrefix(r.URL.Path, prefix), "posts/") {
+			postMedia.ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/auth"
+	"SocialMedia/storage"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+const testMediaBaseURL = "http://localhost/media"
+
+// uploadFile es un adjunto de un formulario de subida. contentType es el que
+// declara el cliente, que el servidor no deberia creerse.
+type uploadFile struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// createPost envia un formulario multipart a CreatePost como alice.
+func createPost(t *testing.T, s PostService, content string, files []uploadFile) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("content", content); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files"; filename=%q`, file.filename))
+		header.Set("Content-Type", file.contentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/posts", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: "alice"}))
+	rec := httptest.NewRecorder()
+	s.CreatePost(rec, req)
+	return rec
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCreatePostTextOnly(t *testing.T) {
+	repo := newFakePostsRepository()
+	s := NewPostService(repo, nil, storage.NewMemoryStorage(testMediaBaseURL))
+
+	rec := createPost(t, s, "hola", nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, se esperaba %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var post data.Post
+	if err := json.NewDecoder(rec.Body).Decode(&post); err != nil {
+		t.Fatal(err)
+	}
+	if post.Content != "hola" || len(post.Media) != 0 {
+		t.Errorf("post = %+v, se esperaba solo texto", post)
+	}
+	if _, ok := repo.posts[post.ID]; !ok {
+		t.Error("el post no se ha guardado")
+	}
+}
+
+func TestCreatePostRejectedAttachments(t *testing.T) {
+	tooMany := make([]uploadFile, maxAttachments+1)
+	for i := range tooMany {
+		tooMany[i] = uploadFile{fmt.Sprintf("%d.png", i), "image/png", testPNG(t)}
+	}
+	// Una cabecera GIF basta para que el tipo detectado sea image/gif.
+	bigGIF := append([]byte("GIF89a"), make([]byte, allowedMediaTypes["image/gif"].maxSize)...)
+
+	tests := []struct {
+		name       string
+		files      []uploadFile
+		wantStatus int
+	}{
+		{"demasiados adjuntos", tooMany, http.StatusBadRequest},
+		{"archivo demasiado grande", []uploadFile{{"big.gif", "image/gif", bigGIF}}, http.StatusRequestEntityTooLarge},
+		{"tipo no permitido", []uploadFile{{"page.png", "image/png", []byte("<html><body>hola</body></html>")}}, http.StatusUnsupportedMediaType},
+		{"tipo no permitido tras uno valido", []uploadFile{{"ok.png", "image/png", testPNG(t)}, {"script.gif", "image/gif", []byte("#!/bin/sh\necho hola\n")}}, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakePostsRepository()
+			s := NewPostService(repo, nil, storage.NewMemoryStorage(testMediaBaseURL))
+
+			rec := createPost(t, s, "hola", tt.files)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, se esperaba %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if len(repo.posts) != 0 {
+				t.Errorf("se han guardado %d posts, no se esperaba ninguno", len(repo.posts))
+			}
+		})
+	}
+}
+
+// El Content-Type guardado sale del contenido y la key se genera en el
+// servidor, sin usar el nombre ni el tipo que envia el cliente.
+func TestCreatePostStoredMedia(t *testing.T) {
+	repo := newFakePostsRepository()
+	bs := storage.NewMemoryStorage(testMediaBaseURL)
+	s := NewPostService(repo, nil, bs)
+
+	file := uploadFile{"../../avatars/bob.html", "text/html", testPNG(t)}
+	var keys []string
+	for i := 0; i < 2; i++ {
+		rec := createPost(t, s, "", []uploadFile{file})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d, se esperaba %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+		}
+		var post data.Post
+		if err := json.NewDecoder(rec.Body).Decode(&post); err != nil {
+			t.Fatal(err)
+		}
+		// La key no se devuelve al cliente, solo se guarda con el post.
+		stored := repo.posts[post.ID]
+		if len(stored.Media) != 1 {
+			t.Fatalf("%d adjuntos, se esperaba 1", len(stored.Media))
+		}
+		media := stored.Media[0]
+		if media.ContentType != "image/png" {
+			t.Errorf("ContentType = %q, se esperaba image/png", media.ContentType)
+		}
+		if !strings.HasPrefix(media.Key, "posts/"+post.ID+"/") || strings.Contains(media.Key, "bob") || strings.Contains(media.Key, "..") {
+			t.Errorf("key = %q, se esperaba una key aleatoria bajo posts/%s/", media.Key, post.ID)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/media/"+media.Key, nil)
+		rec = httptest.NewRecorder()
+		bs.(http.Handler).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", media.Key, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "image/png" {
+			t.Errorf("Content-Type guardado = %q, se esperaba image/png", got)
+		}
+		keys = append(keys, media.Key)
+	}
+	if keys[0] == keys[1] {
+		t.Errorf("dos subidas del mismo archivo comparten la key %q", keys[0])
+	}
+}
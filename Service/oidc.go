@@ -0,0 +1,279 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/oidc"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const oidcFlowDuration = 10 * time.Minute
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// oidcFlow es lo que se guarda en una cookie entre la redireccion al
+// proveedor y el callback.
+type oidcFlow struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+}
+
+func newOIDCFlow() (oidcFlow, error) {
+	state, err := oidc.RandomString(16)
+	if err != nil {
+		return oidcFlow{}, err
+	}
+	nonce, err := oidc.RandomString(16)
+	if err != nil {
+		return oidcFlow{}, err
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		return oidcFlow{}, err
+	}
+	return oidcFlow{State: state, Nonce: nonce, CodeVerifier: verifier}, nil
+}
+
+// OIDCLogin redirige al proveedor de /auth/oidc/{provider}/login para
+// iniciar sesion con el flujo authorization code + PKCE.
+func (s *userService) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.providers[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, oidc.ErrUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+
+	flow, err := newOIDCFlow()
+	if err != nil {
+		log.Printf("Error generando el flujo OIDC: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), flow.State, flow.Nonce, oidc.CodeChallengeS256(flow.CodeVerifier))
+	if err != nil {
+		log.Printf("Error con el proveedor OIDC: %v", err)
+		http.Error(w, "El proveedor de login no esta disponible", http.StatusBadGateway)
+		return
+	}
+
+	value, err := json.Marshal(flow)
+	if err != nil {
+		log.Printf("Error generando el flujo OIDC: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, oidcCookie(r, provider.Name(), base64.RawURLEncoding.EncodeToString(value), int(oidcFlowDuration.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback recibe el codigo del proveedor, lo canjea y verifica el ID
+// token, y busca o crea el usuario enlazado a esa identidad. Vuelve a la web
+// con la sesion en las cookies, o con el token de /login/2fa en el fragmento
+// de la URL.
+func (s *userService) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.providers[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, oidc.ErrUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName(provider.Name()))
+	if err != nil {
+		http.Error(w, "El login ha caducado, vuelve a intentarlo", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, oidcCookie(r, provider.Name(), "", -1))
+
+	var flow oidcFlow
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err == nil {
+		err = json.Unmarshal(value, &flow)
+	}
+	query := r.URL.Query()
+	if err != nil || flow.State == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(query.Get("state"))) != 1 {
+		http.Error(w, "state invalido", http.StatusBadRequest)
+		return
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		http.Error(w, "El proveedor rechazo el login: "+errorCode, http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		log.Printf("Error completando el login con %s: %v", provider.Name(), err)
+		http.Error(w, "No se pudo completar el login", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.userForIdentity(provider.Name(), idToken)
+	if err != nil {
+		log.Printf("Error obteniendo el usuario de %s: %v", provider.Name(), err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var response map[string]interface{}
+	if user.TOTPEnabled {
+		response, err = mfaChallenge(user)
+	} else {
+		response, err = s.sessionTokens(w, r, user)
+	}
+	if err != nil {
+		log.Printf("Error generando el token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Los tokens de sesion ya van en las cookies; el fragmento, que no se
+	// envia al servidor ni queda en los logs, solo lleva lo que la web
+	// necesita para seguir.
+	fragment := url.Values{"username": {user.Username}}
+	if user.TOTPEnabled {
+		fragment.Set("mfaRequired", "true")
+		fragment.Set("mfaToken", fmt.Sprint(response["mfaToken"]))
+	}
+	http.Redirect(w, r, appBaseURL()+"/#"+fragment.Encode(), http.StatusFound)
+}
+
+// userForIdentity devuelve el usuario enlazado a la identidad. Si no hay
+// ninguno se enlaza al unico usuario con ese email verificado, si el
+// proveedor tambien lo da por verificado, o se crea un usuario nuevo.
+func (s *userService) userForIdentity(provider string, idToken *oidc.IDToken) (*data.User, error) {
+	user, err := s.userRepo.GetUserByIdentity(provider, idToken.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	if idToken.Email != "" && idToken.EmailVerified {
+		users, err := s.userRepo.GetUsersByEmail(idToken.Email)
+		if err != nil {
+			return nil, err
+		}
+		var verified []*data.User
+		for _, u := range users {
+			if u.EmailVerified {
+				verified = append(verified, u)
+			}
+		}
+		if len(verified) == 1 {
+			err := s.userRepo.LinkIdentity(verified[0].Username, provider, idToken.Subject)
+			if errors.Is(err, Repositories.ErrIdentityLinked) {
+				return s.linkedUser(provider, idToken.Subject)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return verified[0], nil
+		}
+	}
+
+	// La cuenta nueva no tiene contrasena utilizable; se puede poner una
+	// con /password/forgot.
+	password, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	base := usernameFromIdentity(idToken)
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, err
+			}
+			username = fmt.Sprintf("%s%04d", base[:min(len(base), 16)], suffix)
+		}
+
+		existing, err := s.userRepo.GetUser(username)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		err = s.userRepo.CreateUserWithIdentity(username, string(hashedPassword), idToken.Email, idToken.EmailVerified, provider, idToken.Subject)
+		if errors.Is(err, Repositories.ErrUsernameTaken) {
+			continue
+		}
+		if errors.Is(err, Repositories.ErrIdentityLinked) {
+			return s.linkedUser(provider, idToken.Subject)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.userRepo.GetUser(username)
+	}
+	return nil, errors.New("no se encontro un username libre")
+}
+
+// linkedUser devuelve el usuario al que otro login simultaneo con la misma
+// identidad la acaba de enlazar.
+func (s *userService) linkedUser(provider, subject string) (*data.User, error) {
+	user, err := s.userRepo.GetUserByIdentity(provider, subject)
+	if err == nil && user == nil {
+		err = Repositories.ErrIdentityLinked
+	}
+	return user, err
+}
+
+// usernameFromIdentity propone un username valido (alfanumerico, de 4 a 20
+// caracteres) a partir de los datos del proveedor.
+func usernameFromIdentity(idToken *oidc.IDToken) string {
+	candidate := idToken.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	if candidate == "" {
+		candidate = idToken.Name
+	}
+
+	username := nonAlphanumeric.ReplaceAllString(candidate, "")
+	if len(username) > 20 {
+		username = username[:20]
+	}
+	if len(username) < 4 {
+		username = "user" + username
+	}
+	return username
+}
+
+func oidcCookieName(provider string) string {
+	return "oidc_" + provider
+}
+
+// oidcCookie guarda el flujo solo para el callback del proveedor. SameSite
+// Lax permite que llegue en la redireccion desde el proveedor.
+func oidcCookie(r *http.Request, provider, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookieName(provider),
+		Value:    value,
+		Path:     "/auth/oidc/" + provider + "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/oidc"
+	"testing"
+)
+
+func TestUserForIdentityConcurrentCreate(t *testing.T) {
+	repo := newFakeUserRepository()
+	s := &userService{userRepo: repo}
+	idToken := &oidc.IDToken{Subject: "sub-1", PreferredUsername: "alice"}
+
+	// Otro callback con la misma identidad crea el usuario justo antes.
+	repo.beforeCreate = func() {
+		repo.beforeCreate = nil
+		repo.mu.Lock()
+		repo.users["alice2"] = &data.User{Username: "alice2"}
+		repo.identities["mock|sub-1"] = "alice2"
+		repo.mu.Unlock()
+	}
+
+	user, err := s.userForIdentity("mock", idToken)
+	if err != nil {
+		t.Fatalf("userForIdentity: %v", err)
+	}
+	if user.Username != "alice2" {
+		t.Fatalf("usuario = %s, se esperaba el enlazado por el otro login (alice2)", user.Username)
+	}
+	if len(repo.users) != 1 {
+		t.Fatalf("usuarios creados = %d, se esperaba 1", len(repo.users))
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// GetPrivacy devuelve la configuracion de privacidad del usuario autenticado.
+func (s *userService) GetPrivacy(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	writePrivacy(w, user.Privacy)
+}
+
+// UpdatePrivacy cambia la configuracion de privacidad. Los campos que no
+// vienen en el cuerpo se quedan como estaban.
+func (s *userService) UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PrivateProfile *bool   `json:"privateProfile"`
+		FriendRequests *string `json:"friendRequests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if req.FriendRequests != nil && !data.IsValidFriendRequestPolicy(*req.FriendRequests) {
+		http.Error(w, "friendRequests debe ser everyone, friends_of_friends o nobody", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+
+	settings := user.Privacy
+	if req.PrivateProfile != nil {
+		settings.PrivateProfile = *req.PrivateProfile
+	}
+	if req.FriendRequests != nil {
+		settings.FriendRequests = *req.FriendRequests
+	}
+
+	if err := s.userRepo.UpdatePrivacy(username, settings); err != nil {
+		if errors.Is(err, Repositories.ErrUserNotFound) {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error actualizando la privacidad: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writePrivacy(w, settings)
+}
+
+func writePrivacy(w http.ResponseWriter, settings data.PrivacySettings) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdatePrivacy(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       data.PrivacySettings
+	}{
+		{"perfil privado", `{"privateProfile":true}`, http.StatusOK,
+			data.PrivacySettings{PrivateProfile: true, FriendRequests: data.FriendRequestsEveryone}},
+		{"everyone", `{"friendRequests":"everyone"}`, http.StatusOK,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsEveryone}},
+		{"friends_of_friends", `{"friendRequests":"friends_of_friends"}`, http.StatusOK,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsFriendsOfFriends}},
+		{"nobody", `{"friendRequests":"nobody"}`, http.StatusOK,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsNobody}},
+		{"ambos campos", `{"privateProfile":true,"friendRequests":"nobody"}`, http.StatusOK,
+			data.PrivacySettings{PrivateProfile: true, FriendRequests: data.FriendRequestsNobody}},
+		{"politica desconocida", `{"friendRequests":"friends"}`, http.StatusBadRequest,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsEveryone}},
+		{"politica vacia", `{"friendRequests":""}`, http.StatusBadRequest,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsEveryone}},
+		{"cuerpo invalido", `{`, http.StatusBadRequest,
+			data.PrivacySettings{FriendRequests: data.FriendRequestsEveryone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(&data.User{
+				Username: "alice",
+				Privacy:  data.PrivacySettings{FriendRequests: data.FriendRequestsEveryone},
+			})
+			s := NewUserService(repo, Repositories.NewInMemoryTokenRepository(), nil, nil, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPut, "/privacy", strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: "alice"}))
+			rec := httptest.NewRecorder()
+			s.UpdatePrivacy(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, se esperaba %d", rec.Code, tt.wantStatus)
+			}
+			user, _ := repo.GetUser("alice")
+			if user.Privacy != tt.want {
+				t.Errorf("privacidad = %+v, se esperaba %+v", user.Privacy, tt.want)
+			}
+		})
+	}
+}
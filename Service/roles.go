@@ -0,0 +1,100 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/auth"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SetRoles reemplaza los roles del usuario de la ruta. Solo para admins.
+// Todos los usuarios tienen el rol user. Se revocan sus sesiones para que
+// los tokens con los roles anteriores dejen de valer.
+func (s *userService) SetRoles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	roles := []string{data.RoleUser}
+	for _, role := range req.Roles {
+		if !data.IsValidRole(role) {
+			http.Error(w, "rol invalido: "+role, http.StatusBadRequest)
+			return
+		}
+		if !containsString(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	username := r.PathValue("username")
+	if err := s.userRepo.SetRoles(username, roles); err != nil {
+		if errors.Is(err, Repositories.ErrUserNotFound) {
+			http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, Repositories.ErrLastAdmin) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Error actualizando los roles: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.tokenRepo.RevokeUserTokens(username, time.Now()); err != nil {
+		log.Printf("Error revocando las sesiones: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	admin, _ := auth.Username(r.Context())
+	log.Printf("Admin: %s cambio los roles de %s a %v", admin, username, roles)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"username": username, "roles": roles}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// BootstrapAdmins da el rol admin a los usuarios de la variable
+// ADMIN_USERNAMES (separados por comas). Sirve para crear el primer admin,
+// que no se puede nombrar desde la API, o recuperar el acceso si no queda
+// ninguno. Los usuarios que no existen se ignoran con un aviso.
+func BootstrapAdmins(userRepo Repositories.UserRepository) error {
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		err := userRepo.AddRole(username, data.RoleAdmin)
+		if errors.Is(err, Repositories.ErrUserNotFound) {
+			log.Printf("Aviso: el usuario %s de ADMIN_USERNAMES no existe", username)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Admin: %s tiene el rol admin por ADMIN_USERNAMES", username)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
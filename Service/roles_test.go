@@ -0,0 +1,60 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setRoles(s UserService, username, body string) int {
+	req := httptest.NewRequest(http.MethodPut, "/admin/users/"+username+"/roles", strings.NewReader(body))
+	req.SetPathValue("username", username)
+	rec := httptest.NewRecorder()
+	s.SetRoles(rec, req)
+	return rec.Code
+}
+
+func TestSetRolesKeepsLastAdmin(t *testing.T) {
+	repo := newFakeUserRepository(
+		&data.User{Username: "alice", Roles: []string{data.RoleUser, data.RoleAdmin}},
+		&data.User{Username: "bob", Roles: []string{data.RoleUser}},
+	)
+	s := NewUserService(repo, Repositories.NewInMemoryTokenRepository(), nil, nil, nil, nil)
+
+	if code := setRoles(s, "alice", `{"roles":[]}`); code != http.StatusConflict {
+		t.Fatalf("quitar el ultimo admin: status %d, se esperaba 409", code)
+	}
+	if code := setRoles(s, "bob", `{"roles":["admin"]}`); code != http.StatusOK {
+		t.Fatalf("nombrar a bob admin: status %d, se esperaba 200", code)
+	}
+	if code := setRoles(s, "alice", `{"roles":[]}`); code != http.StatusOK {
+		t.Fatalf("quitar un admin que no es el ultimo: status %d, se esperaba 200", code)
+	}
+	if code := setRoles(s, "bob", `{"roles":["moderator"]}`); code != http.StatusConflict {
+		t.Fatalf("quitar el nuevo ultimo admin: status %d, se esperaba 409", code)
+	}
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", " alice , ghost,,")
+	repo := newFakeUserRepository(&data.User{Username: "alice", Roles: []string{data.RoleUser}})
+
+	if err := BootstrapAdmins(repo); err != nil {
+		t.Fatalf("BootstrapAdmins: %v", err)
+	}
+	user, _ := repo.GetUser("alice")
+	if !containsString(user.Roles, data.RoleAdmin) {
+		t.Fatalf("roles de alice = %v, se esperaba admin", user.Roles)
+	}
+	// Repetirlo en cada arranque no duplica el rol.
+	if err := BootstrapAdmins(repo); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = repo.GetUser("alice")
+	if len(user.Roles) != 2 {
+		t.Fatalf("roles de alice = %v, se esperaba [user admin]", user.Roles)
+	}
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"SocialMedia/Repositories"
+	"SocialMedia/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+// LoginTwoFactor completa el login de una cuenta con verificacion en dos
+// pasos con el mfaToken de LoginUser y un codigo de la app o un codigo de
+// recuperacion. Los codigos incorrectos cuentan como intentos fallidos.
+func (s *userService) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken     string `json:"mfaToken"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	claims, err := utils.ValidateActionToken(req.MFAToken, utils.MFAPendingTokenType)
+	if err != nil {
+		http.Error(w, "token invalido", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := s.tokenRepo.IsTokenRevoked(claims.Id)
+	if err != nil {
+		log.Printf("Error comprobando la revocacion del token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		http.Error(w, "token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	attempt, ok := s.startLoginAttempt(w, claims.Username, clientIP(r))
+	if !ok {
+		return
+	}
+
+	user, err := s.userRepo.GetUser(claims.Username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || !user.TOTPEnabled {
+		http.Error(w, "token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	var reason string
+	switch {
+	case req.Code != "":
+		counter, ok := utils.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			reason = "codigo 2FA incorrecto"
+		} else if err := s.userRepo.UseTOTPCounter(user.Username, counter); err != nil {
+			if !errors.Is(err, Repositories.ErrTOTPCodeUsed) {
+				log.Printf("Error guardando el codigo 2FA usado: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			reason = "codigo 2FA reutilizado"
+		}
+	case req.RecoveryCode != "":
+		reason = "codigo de recuperacion incorrecto"
+		code := utils.NormalizeRecoveryCode(req.RecoveryCode)
+		for _, hash := range user.RecoveryCodes {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+				continue
+			}
+			if err := s.userRepo.UseRecoveryCode(user.Username, hash); err == nil {
+				reason = ""
+			} else if !errors.Is(err, Repositories.ErrRecoveryCodeUsed) {
+				log.Printf("Error guardando el codigo de recuperacion usado: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			break
+		}
+	default:
+		http.Error(w, "Falta el codigo", http.StatusBadRequest)
+		return
+	}
+
+	if reason != "" {
+		s.limiter.Failure(r.Context(), attempt, user, reason)
+		http.Error(w, "Codigo invalido", http.StatusUnauthorized)
+		return
+	}
+	if err := s.limiter.Release(attempt); err != nil {
+		log.Printf("Error liberando el intento de inicio de sesion: %v", err)
+	}
+
+	used, err := s.tokenRepo.RevokeToken(claims.Id, claims.ExpiresAtTime())
+	if err != nil {
+		log.Printf("Error revocando el token 2FA: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "token invalido", http.StatusUnauthorized)
+		return
+	}
+
+	s.completeLogin(w, r, user)
+}
+
+// SetupTwoFactor genera un secreto TOTP y devuelve la URI otpauth:// para
+// mostrarla como QR. No se activa hasta confirmarlo con ConfirmTwoFactor.
+func (s *userService) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "la verificacion en dos pasos ya esta activada", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generando el secreto TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.userRepo.SetPendingTOTP(username, secret); err != nil {
+		log.Printf("Error guardando el secreto TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "SocialMedia"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"secret":          secret,
+		"provisioningURI": utils.TOTPProvisioningURI(issuer, username, secret),
+	}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// ConfirmTwoFactor activa la verificacion en dos pasos con un codigo del
+// secreto de SetupTwoFactor y devuelve los codigos de recuperacion. Es la
+// unica vez que se muestran.
+func (s *userService) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		http.Error(w, Repositories.ErrNoPendingTOTP.Error(), http.StatusConflict)
+		return
+	}
+
+	counter, ok := utils.ValidateTOTP(user.TOTPPendingSecret, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Codigo invalido", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Printf("Error generando los codigos de recuperacion: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(utils.NormalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hasheando el codigo de recuperacion: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		hashes[i] = string(hash)
+	}
+
+	if err := s.userRepo.EnableTOTP(username, user.TOTPPendingSecret, counter, hashes); err != nil {
+		if errors.Is(err, Repositories.ErrNoPendingTOTP) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Error activando la verificacion en dos pasos: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": codes}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// DisableTwoFactor desactiva la verificacion en dos pasos tras comprobar la
+// contrasena.
+func (s *userService) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.checkPassword(w, r, username, req.Password); !ok {
+		return
+	}
+
+	if err := s.userRepo.DisableTOTP(username); err != nil {
+		log.Printf("Error desactivando la verificacion en dos pasos: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/auth"
+	"SocialMedia/utils"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// totpCodeAt calcula el codigo TOTP de secret en t, como la app del usuario.
+func totpCodeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1_000_000)
+}
+
+// El codigo con el que se confirma la verificacion en dos pasos no se puede
+// volver a usar para iniciar sesion.
+func TestConfirmTwoFactorCodeCannotBeReplayed(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+	repo := newFakeUserRepository(&data.User{Username: "alice", TOTPPendingSecret: testTOTPSecret})
+	limiter := NewLoginLimiter(Repositories.NewInMemoryLoginAttemptRepository(), nil)
+	s := NewUserService(repo, Repositories.NewInMemoryTokenRepository(), nil, nil, limiter, nil)
+	code := totpCodeAt(t, testTOTPSecret, time.Now())
+
+	req := httptest.NewRequest(http.MethodPost, "/2fa/confirm", strings.NewReader(`{"code":"`+code+`"}`))
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Username: "alice"}))
+	rec := httptest.NewRecorder()
+	s.ConfirmTwoFactor(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ConfirmTwoFactor: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	mfaToken, err := utils.GenerateActionToken("alice", utils.MFAPendingTokenType, "", utils.MFAPendingDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/login/2fa",
+		strings.NewReader(`{"mfaToken":"`+mfaToken+`","code":"`+code+`"}`))
+	rec = httptest.NewRecorder()
+	s.LoginTwoFactor(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("LoginTwoFactor con el codigo de la confirmacion: status %d, se esperaba 401", rec.Code)
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/mail"
+	"SocialMedia/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	emailVerificationTokenDuration = 48 * time.Hour
+	passwordResetTokenDuration     = time.Hour
+)
+
+// VerifyEmail marca el email del usuario como verificado con el token que se
+// le envio al registrarse.
+func (s *userService) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	claims, err := utils.ValidateActionToken(req.Token, utils.EmailVerificationTokenType)
+	if err != nil {
+		http.Error(w, "token invalido", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.userRepo.VerifyEmail(claims.Username, claims.Value); err != nil {
+		if errors.Is(err, Repositories.ErrEmailChanged) {
+			http.Error(w, "token invalido", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error verificando el email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification vuelve a enviar el correo de verificacion al usuario
+// autenticado.
+func (s *userService) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUsername(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.userRepo.GetUser(username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, "el email ya esta verificado", http.StatusConflict)
+		return
+	}
+
+	if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error enviando la verificacion de email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPassword envia un enlace para cambiar la contrasena a los usuarios
+// con ese email. Siempre responde 202 para no revelar que emails existen.
+func (s *userService) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !isValidEmail(req.Email) {
+		http.Error(w, "email invalido", http.StatusBadRequest)
+		return
+	}
+
+	users, err := s.userRepo.GetUsersByEmail(req.Email)
+	if err != nil {
+		log.Printf("Error buscando usuarios por email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	for _, user := range users {
+		token, err := utils.GenerateActionToken(user.Username, utils.PasswordResetTokenType, passwordFingerprint(user.PasswordHash), passwordResetTokenDuration)
+		if err != nil {
+			log.Printf("Error generando el token: %v", err)
+			continue
+		}
+		if err := s.sendEmail(r.Context(), user.Email, passwordResetMessage(user.Username, token)); err != nil {
+			log.Printf("Error enviando el correo de recuperacion a %s: %v", user.Username, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword cambia la contrasena con el token de ForgotPassword y
+// revoca todas las sesiones. El token deja de valer en cuanto cambia la
+// contrasena, asi que solo se puede usar una vez.
+func (s *userService) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	claims, err := utils.ValidateActionToken(req.Token, utils.PasswordResetTokenType)
+	if err != nil {
+		http.Error(w, "token invalido", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userRepo.GetUser(claims.Username)
+	if err != nil {
+		log.Printf("Error al obtener el usuario: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || passwordFingerprint(user.PasswordHash) != claims.Value {
+		http.Error(w, "token invalido", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hasheando la contrasena: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.userRepo.UpdatePassword(user.Username, string(hashedPassword)); err != nil {
+		log.Printf("Error actualizando la contrasena: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.tokenRepo.RevokeUserTokens(user.Username, time.Now()); err != nil {
+		log.Printf("Error revocando las sesiones: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passwordFingerprint identifica el hash de la contrasena actual sin
+// incluirlo en el token.
+func passwordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *userService) sendVerificationEmail(ctx context.Context, user *data.User) error {
+	token, err := utils.GenerateActionToken(user.Username, utils.EmailVerificationTokenType, user.Email, emailVerificationTokenDuration)
+	if err != nil {
+		return err
+	}
+	return s.sendEmail(ctx, user.Email, verificationMessage(user.Username, token))
+}
+
+func (s *userService) sendEmail(ctx context.Context, to string, msg mail.Message) error {
+	msg.To = to
+	return s.mailer.Send(ctx, msg)
+}
+
+func verificationMessage(username, token string) mail.Message {
+	return mail.Message{
+		Subject: "Verifica tu email",
+		Body: fmt.Sprintf("Hola %s,\n\nConfirma tu email abriendo este enlace:\n\n%s\n\nEl enlace caduca en %d horas.\n",
+			username, appLink("verify-email", token), int(emailVerificationTokenDuration.Hours())),
+	}
+}
+
+func emailChangeMessage(username, token string) mail.Message {
+	return mail.Message{
+		Subject: "Confirma tu nuevo email",
+		Body: fmt.Sprintf("Hola %s,\n\nPara usar este email en tu cuenta abre este enlace:\n\n%s\n\nSi no has pedido el cambio, ignora este correo.\n",
+			username, appLink("confirm-email", token)),
+	}
+}
+
+func passwordResetMessage(username, token string) mail.Message {
+	return mail.Message{
+		Subject: "Recupera tu contrasena",
+		Body: fmt.Sprintf("Hola %s,\n\nPara elegir una nueva contrasena abre este enlace:\n\n%s\n\nEl enlace caduca en %d minutos. Si no lo has pedido tu, ignora este correo.\n",
+			username, appLink("reset-password", token), int(passwordResetTokenDuration.Minutes())),
+	}
+}
+
+// appLink devuelve el enlace de la web (APP_BASE_URL) que recibe el token en
+// el fragmento, con action como clave, y llama al endpoint correspondiente.
+// Como en OIDCCallback, el fragmento no se envia al servidor, asi que el
+// token no queda en los logs ni en la cabecera Referer.
+func appLink(action, token string) string {
+	return appBaseURL() + "/#" + url.Values{action: {token}}.Encode()
+}
+
+// appBaseURL devuelve la URL de la web (APP_BASE_URL) sin la barra final.
+func appBaseURL() string {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
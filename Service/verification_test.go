@@ -0,0 +1,74 @@
+package service
+
+import (
+	data "SocialMedia/Data"
+	"SocialMedia/Repositories"
+	"SocialMedia/mail"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// mailedToken devuelve el token del enlace del correo, que debe ir en el
+// fragmento con la clave action y no en la query.
+func mailedToken(t *testing.T, msg mail.Message, action string) string {
+	t.Helper()
+
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil {
+		t.Fatalf("enlace invalido en el correo: %v", err)
+	}
+	if link.RawQuery != "" {
+		t.Errorf("el enlace lleva query %q, el token debe ir en el fragmento", link.RawQuery)
+	}
+	fragment, err := url.ParseQuery(link.Fragment)
+	if err != nil {
+		t.Fatalf("fragmento invalido: %v", err)
+	}
+	token := fragment.Get(action)
+	if token == "" {
+		t.Fatalf("el fragmento %q no tiene %s", link.Fragment, action)
+	}
+	return token
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+	t.Setenv("APP_BASE_URL", "https://social.example/")
+
+	user := &data.User{Username: "alice", Email: "alice@example.com", PasswordHash: "old-hash"}
+	mailer := mail.NewMemoryMailer()
+	s := NewUserService(newFakeUserRepository(user), Repositories.NewInMemoryTokenRepository(), nil, mailer, nil, nil)
+
+	rec := httptest.NewRecorder()
+	s.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email":"alice@example.com"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword: status %d, se esperaba 202", rec.Code)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != user.Email {
+		t.Fatalf("correos enviados = %+v, se esperaba uno a %s", messages, user.Email)
+	}
+	if !strings.Contains(messages[0].Body, "https://social.example/#reset-password=") {
+		t.Errorf("el correo no enlaza a la web con el fragmento: %q", messages[0].Body)
+	}
+	token := mailedToken(t, messages[0], "reset-password")
+
+	reset := func() int {
+		rec := httptest.NewRecorder()
+		body := `{"token":"` + token + `","newPassword":"NuevaClave123!"}`
+		s.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := reset(); code != http.StatusNoContent {
+		t.Fatalf("ResetPassword: status %d, se esperaba 204", code)
+	}
+	if code := reset(); code != http.StatusBadRequest {
+		t.Fatalf("ResetPassword con el token usado: status %d, se esperaba 400", code)
+	}
+}
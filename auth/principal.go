@@ -0,0 +1,75 @@
+// Package auth guarda en el contexto de la peticion quien esta autenticado.
+// Lo pone middleware.AuthMiddleware (o middleware.Scoped) y lo leen los
+// servicios.
+package auth
+
+import (
+	data "SocialMedia/Data"
+	"context"
+	"time"
+)
+
+// Principal es el usuario autenticado de una peticion, con un access token
+// (TokenID) o con una API key (APIKeyID).
+type Principal struct {
+	UserID         string
+	Username       string
+	Roles          []string
+	TokenID        string
+	TokenExpiresAt time.Time
+	APIKeyID       string
+}
+
+// HasRole indica si el usuario tiene los permisos de role. Las API keys no
+// tienen roles.
+func (p *Principal) HasRole(role string) bool {
+	return data.HasRole(p.Roles, role)
+}
+
+// contextKey es privado para que ningun otro paquete pueda pisar el valor.
+type contextKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext devuelve el usuario autenticado, o false si la peticion no paso
+// por el middleware de autenticacion.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+func UserID(ctx context.Context) (string, bool) {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return principal.UserID, true
+}
+
+func Username(ctx context.Context) (string, bool) {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return principal.Username, true
+}
+
+func Roles(ctx context.Context) []string {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return principal.Roles
+}
+
+// TokenID devuelve el jti del access token; false si no hay usuario o se
+// autentico con una API key.
+func TokenID(ctx context.Context) (string, bool) {
+	principal, ok := FromContext(ctx)
+	if !ok || principal.TokenID == "" {
+		return "", false
+	}
+	return principal.TokenID, true
+}
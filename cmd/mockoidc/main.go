@@ -0,0 +1,244 @@
+// Command mockoidc es un proveedor OIDC minimo para probar el login social en
+// local. No pide contrasena: el formulario de /authorize deja elegir el
+// usuario con el que se inicia sesion.
+//
+//	go run ./cmd/mockoidc
+//
+// y en el .env del servidor:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_ID=socialmedia
+package main
+
+import (
+	"SocialMedia/oidc"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	keyID        = "mock"
+	codeDuration = time.Minute
+)
+
+// authRequest es lo que se recuerda de cada codigo de autorizacion emitido.
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+	name          string
+	expiresAt     time.Time
+}
+
+type server struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+var authorizeForm = template.Must(template.New("authorize").Parse(`<!doctype html>
+<html>
+  <body>
+    <h1>Mock OIDC</h1>
+    <form method="post">
+      {{range $name, $values := .Query}}<input type="hidden" name="{{$name}}" value="{{index $values 0}}">
+      {{end}}
+      <label>sub <input name="sub" value="mock-user-1"></label><br>
+      <label>email <input name="email" value="mock@example.com"></label><br>
+      <label>name <input name="name" value="Mock User"></label><br>
+      <button type="submit">Iniciar sesion</button>
+    </form>
+  </body>
+</html>`))
+
+func main() {
+	addr := getEnv("MOCK_OIDC_ADDR", ":9000")
+	issuer := getEnv("MOCK_OIDC_ISSUER", "http://localhost:9000")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Error generando la clave: %v", err)
+	}
+	s := &server{issuer: issuer, key: key, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("GET /authorize", s.authorizePage)
+	mux.HandleFunc("POST /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+
+	log.Printf("Mock OIDC en %s (issuer %s)", addr, issuer)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": keyID,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *server) authorizePage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "solo se admite response_type=code con PKCE S256", http.StatusBadRequest)
+		return
+	}
+	if err := authorizeForm.Execute(w, map[string]url.Values{"Query": query}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// authorize emite un codigo para el usuario del formulario y vuelve a la
+// redirect_uri del cliente.
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "formulario invalido", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.PostForm.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "redirect_uri invalida", http.StatusBadRequest)
+		return
+	}
+
+	code, err := oidc.RandomString(16)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.codes[code] = authRequest{
+		clientID:      r.PostForm.Get("client_id"),
+		redirectURI:   redirectURI.String(),
+		nonce:         r.PostForm.Get("nonce"),
+		codeChallenge: r.PostForm.Get("code_challenge"),
+		subject:       r.PostForm.Get("sub"),
+		email:         r.PostForm.Get("email"),
+		name:          r.PostForm.Get("name"),
+		expiresAt:     time.Now().Add(codeDuration),
+	}
+	s.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.PostForm.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token canjea un codigo comprobando el code verifier de PKCE y devuelve un
+// ID token firmado con RS256.
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	req, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(req.expiresAt) ||
+		req.clientID != r.PostForm.Get("client_id") ||
+		req.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != req.codeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            req.subject,
+		"aud":            req.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          req.nonce,
+		"email":          req.email,
+		"email_verified": req.email != "",
+		"name":           req.name,
+	})
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		log.Printf("Error firmando el ID token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := oidc.RandomString(32)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+import "github.com/neo4j/neo4j-go-driver/v4/neo4j"
+
+// schema son las restricciones e indices que necesitan los repositorios. Se
+// crean con IF NOT EXISTS, asi que se pueden ejecutar en cada arranque.
+var schema = []string{
+	// Los refresh tokens son de un solo uso: RevokeToken se apoya en esta
+	// restriccion para que solo una peticion pueda revocar cada token.
+	`CREATE CONSTRAINT revoked_token_jti IF NOT EXISTS FOR (t:RevokedToken) REQUIRE t.jti IS UNIQUE`,
+	// CreateUser y CreateUserWithIdentity devuelven ErrUsernameTaken cuando
+	// falla esta restriccion, asi que dos registros simultaneos no pueden
+	// quedarse con el mismo username.
+	`CREATE CONSTRAINT user_username IF NOT EXISTS FOR (u:User) REQUIRE u.username IS UNIQUE`,
+	// El id es el identificador estable del usuario (claim uid de los JWT).
+	`CREATE CONSTRAINT user_id IF NOT EXISTS FOR (u:User) REQUIRE u.id IS UNIQUE`,
+	// ReserveAttempt hace MERGE por key: sin la restriccion dos intentos
+	// simultaneos podrian crear dos contadores.
+	`CREATE CONSTRAINT login_attempts_key IF NOT EXISTS FOR (a:LoginAttempts) REQUIRE a.key IS UNIQUE`,
+	// Cada identidad externa pertenece a un solo usuario: LinkIdentity y
+	// CreateUserWithIdentity hacen MERGE contando con esta restriccion.
+	`CREATE CONSTRAINT identity_provider_subject IF NOT EXISTS FOR (i:Identity) REQUIRE (i.provider, i.subject) IS UNIQUE`,
+	// AuthenticateAPIKey busca la API key por su hash en cada peticion; las
+	// restricciones de unicidad crean tambien el indice.
+	`CREATE CONSTRAINT api_key_hash IF NOT EXISTS FOR (k:APIKey) REQUIRE k.hash IS UNIQUE`,
+	`CREATE CONSTRAINT api_key_id IF NOT EXISTS FOR (k:APIKey) REQUIRE k.id IS UNIQUE`,
+	// SetRoles bloquea el unico (:RoleGuard) antes de contar los admins; sin
+	// la restriccion dos MERGE simultaneos podrian crear dos.
+	`CREATE CONSTRAINT role_guard_name IF NOT EXISTS FOR (g:RoleGuard) REQUIRE g.name IS UNIQUE`,
+	// Para la limpieza periodica de service.Cleanup.
+	`CREATE INDEX audit_event_created_at IF NOT EXISTS FOR (e:AuditEvent) ON (e.createdAt)`,
+}
+
+// EnsureSchema crea las restricciones e indices que falten.
+func EnsureSchema(driver neo4j.Driver) error {
+	session := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	for _, statement := range schema {
+		result, err := session.Run(statement, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := result.Consume(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("cabecera de correo invalida")
+
+// Message es un correo de texto plano.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envia los correos de la aplicacion (verificacion de email,
+// recuperacion de contrasena...).
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New crea el Mailer indicado por la variable MAIL_DRIVER ("smtp", "file",
+// "memory" o "none"). Si no se indica se usa SMTP cuando estan SMTP_HOST y
+// MAIL_FROM y, si no, se avisa en el log y los correos se descartan.
+func New() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "":
+		if os.Getenv("SMTP_HOST") == "" || os.Getenv("MAIL_FROM") == "" {
+			log.Printf("Aviso: no hay SMTP_HOST o MAIL_FROM, los correos no se enviaran (usa MAIL_DRIVER=file para guardarlos)")
+			return NewNoopMailer(), nil
+		}
+		return newSMTPMailerFromEnv()
+	case "smtp":
+		return newSMTPMailerFromEnv()
+	case "file":
+		return NewFileMailer(getEnv("MAIL_DIR", "outbox"))
+	case "memory":
+		return NewMemoryMailer(), nil
+	case "none":
+		return NewNoopMailer(), nil
+	default:
+		return nil, fmt.Errorf("MAIL_DRIVER desconocido: %q", driver)
+	}
+}
+
+func newSMTPMailerFromEnv() (Mailer, error) {
+	return NewSMTPMailer(
+		os.Getenv("SMTP_HOST"),
+		getEnv("SMTP_PORT", "587"),
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+		os.Getenv("MAIL_FROM"),
+	)
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// format devuelve el mensaje en formato RFC 5322. Las cabeceras con saltos de
+// linea se rechazan para que no se puedan inyectar otras cabeceras.
+func format(from string, msg Message) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	if from != "" {
+		fmt.Fprintf(&buf, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
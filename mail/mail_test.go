@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewWithoutSMTPConfigDoesNotFail(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "")
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_FROM", "")
+
+	mailer, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := mailer.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hola"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+}
+
+func TestNewExplicitSMTPRequiresConfig(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "smtp")
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("MAIL_FROM", "")
+
+	if _, err := New(); err == nil {
+		t.Fatal("New con MAIL_DRIVER=smtp sin SMTP_HOST deberia fallar")
+	}
+}
+
+func TestMemoryMailerRejectsHeaderInjection(t *testing.T) {
+	mailer := NewMemoryMailer()
+	err := mailer.Send(context.Background(), Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hola"})
+	if err != ErrInvalidHeader {
+		t.Fatalf("err = %v, se esperaba ErrInvalidHeader", err)
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Fatal("el correo con cabeceras inyectadas se guardo")
+	}
+}
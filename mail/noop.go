@@ -0,0 +1,23 @@
+package mail
+
+import (
+	"context"
+	"log"
+)
+
+type noopMailer struct{}
+
+// NewNoopMailer crea un Mailer que descarta los correos. Es el que se usa si
+// no hay envio de correos configurado, para que el servidor pueda arrancar
+// igualmente; los correos de verificacion y recuperacion no llegan.
+func NewNoopMailer() Mailer {
+	return noopMailer{}
+}
+
+func (noopMailer) Send(ctx context.Context, msg Message) error {
+	if _, err := format("", msg); err != nil {
+		return err
+	}
+	log.Printf("Correo a %s descartado, no hay envio de correos configurado: %s", msg.To, msg.Subject)
+	return nil
+}
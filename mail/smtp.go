@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+type smtpMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer crea un Mailer que envia los correos por el servidor SMTP
+// host:port. Si username esta vacio no se autentica.
+func NewSMTPMailer(host, port, username, password, from string) (Mailer, error) {
+	if host == "" || from == "" {
+		return nil, errors.New("faltan SMTP_HOST o MAIL_FROM")
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{
+		addr: net.JoinHostPort(host, port),
+		host: host,
+		auth: auth,
+		from: from,
+	}, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	body, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	// smtp.SendMail no acepta contexto, asi que se espera en otra goroutine.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, body)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -5,8 +5,15 @@ import (
 	routes "SocialMedia/Routes"
 	service "SocialMedia/Service"
 	"SocialMedia/db"
+	"SocialMedia/mail"
+	"SocialMedia/middleware"
+	"SocialMedia/oidc"
+	"SocialMedia/storage"
+	"SocialMedia/utils"
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,22 +24,84 @@ func main() {
 		log.Print("No .env encontrado")
 	}
 
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatalf("Error cargando las claves de los tokens: %v", err)
+	}
+
+	if err := db.EnsureSchema(db.Driver()); err != nil {
+		log.Fatalf("Error creando el esquema de la base de datos: %v", err)
+	}
+
 	friendrepo := Repositories.NewFriendsRepository(db.Driver())
 	postrepo := Repositories.NewPostsRepository(db.Driver())
 	userrepo := Repositories.NewUserRepository(db.Driver())
+	tokenrepo := Repositories.NewTokenRepository(db.Driver())
+	loginattemptrepo := Repositories.NewLoginAttemptRepository(db.Driver())
+	apikeyrepo := Repositories.NewAPIKeyRepository(db.Driver())
+
+	middleware.SetTokenRepository(tokenrepo)
+	middleware.SetAPIKeyRepository(apikeyrepo)
+
+	if err := userrepo.MigrateLegacyUserIDs(); err != nil {
+		log.Printf("Error asignando ids a los usuarios: %v", err)
+	}
+	if err := tokenrepo.MigrateSessionCutoffs(); err != nil {
+		log.Printf("Error migrando los cortes de sesion: %v", err)
+	}
+	if err := friendrepo.MigrateLegacyFriendships(); err != nil {
+		log.Printf("Error migrando amistades: %v", err)
+	}
+	if err := postrepo.MigrateLegacyLikes(); err != nil {
+		log.Printf("Error migrando likes a reacciones: %v", err)
+	}
+	if err := postrepo.MigrateLegacyComments(); err != nil {
+		log.Printf("Error migrando comentarios: %v", err)
+	}
+	if err := postrepo.MigrateLegacyPostTimestamps(); err != nil {
+		log.Printf("Error migrando las fechas de los posts: %v", err)
+	}
+
+	if err := service.BootstrapAdmins(userrepo); err != nil {
+		log.Printf("Error asignando los admins de ADMIN_USERNAMES: %v", err)
+	}
+
+	blobStorage, err := storage.New()
+	if err != nil {
+		log.Fatalf("Error configurando el storage: %v", err)
+	}
+
+	mailer, err := mail.New()
+	if err != nil {
+		log.Fatalf("Error configurando el envio de correos: %v", err)
+	}
 
-	userService := service.NewUserService(userrepo)
-	postService := service.NewPostService(postrepo, friendrepo)
+	oidcProviders, err := oidc.ProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("Error configurando los proveedores OIDC: %v", err)
+	}
+
+	loginLimiter := service.NewLoginLimiter(loginattemptrepo, service.NewMailLockoutNotifier(mailer))
+	userService := service.NewUserService(userrepo, tokenrepo, blobStorage, mailer, loginLimiter, oidcProviders)
+	postService := service.NewPostService(postrepo, friendrepo, blobStorage)
 	friendService := service.NewFriendsService(friendrepo)
+	apiKeyService := service.NewAPIKeyService(apikeyrepo)
 	mux := http.NewServeMux()
 
+	go service.NewAccountPurger(userrepo, blobStorage).Run(context.Background(), time.Hour)
+	go service.NewCleanup(tokenrepo, loginattemptrepo).Run(context.Background(), time.Hour)
+
 	routes.AuthRoutes(mux, userService)
 	routes.PostRoutes(mux, postService)
 	routes.FriendRoutes(mux, friendService)
+	routes.APIKeyRoutes(mux, apiKeyService)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "temp/template.html")
 	})
 
+	if pattern, handler, ok := storage.Handler(blobStorage); ok {
+		mux.Handle(pattern, service.MediaHandler(pattern, handler, postrepo))
+	}
+
 	tempFileServer := http.FileServer(http.Dir("temp"))
 	mux.Handle("/temp/", http.StripPrefix("/temp/", tempFileServer))
 
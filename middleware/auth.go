@@ -1,31 +1,170 @@
 package middleware
 
 import (
+	"SocialMedia/Repositories"
+	"SocialMedia/auth"
 	"SocialMedia/utils"
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
+var (
+	tokenRepo  Repositories.TokenRepository
+	apiKeyRepo Repositories.APIKeyRepository
+
+	errMissingCredentials = errors.New("Authorization header missing")
+	errInvalidAuthHeader  = errors.New("la cabecera Authorization debe ser Bearer <token>")
+)
+
+// SetTokenRepository configura el repositorio de tokens revocados que consulta
+// AuthMiddleware. Sin repositorio no se comprueba la revocacion.
+func SetTokenRepository(repo Repositories.TokenRepository) {
+	tokenRepo = repo
+}
+
+// SetAPIKeyRepository configura el repositorio con el que Scoped comprueba
+// las API keys. Sin repositorio se rechazan todas.
+func SetAPIKeyRepository(repo Repositories.APIKeyRepository) {
+	apiKeyRepo = repo
+}
+
+// AuthMiddleware exige un access token en la cabecera Authorization o en la
+// cookie de sesion de la web. Las API keys no valen: las rutas que las
+// aceptan usan Scoped.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+		token, fromCookie, err := requestToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if utils.IsAPIKey(token) {
+			http.Error(w, "las API keys no sirven para esta ruta", http.StatusUnauthorized)
 			return
 		}
 
-		tokenString := authHeader[7:]
-		claims, err := utils.ValidateToken(tokenString)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		ctx, ok := tokenContext(w, r, token, fromCookie)
+		if !ok {
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		ctx := r.Context()
+// Scoped es como AuthMiddleware pero acepta tambien las API keys que tengan
+// scope. Los access tokens de los usuarios valen para cualquier scope.
+func Scoped(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, fromCookie, err := requestToken(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
 
-		ctx = context.WithValue(ctx, "username", claims.Username)
-		r = r.WithContext(ctx)
+			var ctx context.Context
+			var ok bool
+			if utils.IsAPIKey(token) && !fromCookie {
+				ctx, ok = apiKeyContext(w, r, token, scope)
+			} else {
+				ctx, ok = tokenContext(w, r, token, fromCookie)
+			}
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+// requestToken devuelve el token de la cabecera Authorization o, si no la
+// hay, el de la cookie de sesion.
+func requestToken(r *http.Request) (token string, fromCookie bool, err error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		scheme, token, ok := strings.Cut(authHeader, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" || strings.ContainsAny(token, " \t") {
+			return "", false, errInvalidAuthHeader
+		}
+		return token, false, nil
+	}
+
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, true, nil
+	}
+	return "", false, errMissingCredentials
+}
+
+func tokenContext(w http.ResponseWriter, r *http.Request, tokenString string, fromCookie bool) (context.Context, bool) {
+	if fromCookie && !checkCSRF(w, r) {
+		return nil, false
+	}
+
+	claims, err := utils.ValidateToken(tokenString)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if tokenRepo != nil {
+		revoked, err := IsRevoked(tokenRepo, claims)
+		if err != nil {
+			log.Printf("Error comprobando la revocacion del token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return nil, false
+		}
+		if revoked {
+			http.Error(w, "token revocado", http.StatusUnauthorized)
+			return nil, false
+		}
+	}
+
+	return auth.WithPrincipal(r.Context(), &auth.Principal{
+		UserID:         claims.UserID,
+		Username:       claims.Username,
+		Roles:          claims.Roles,
+		TokenID:        claims.Id,
+		TokenExpiresAt: claims.ExpiresAtTime(),
+	}), true
+}
+
+func apiKeyContext(w http.ResponseWriter, r *http.Request, key, scope string) (context.Context, bool) {
+	if apiKeyRepo == nil {
+		http.Error(w, "API key invalida", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	apiKey, err := apiKeyRepo.AuthenticateAPIKey(utils.HashAPIKey(key), time.Now())
+	if err != nil {
+		log.Printf("Error comprobando la API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if apiKey == nil {
+		http.Error(w, "API key invalida", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !apiKey.HasScope(scope) {
+		http.Error(w, "la API key no tiene el scope "+scope, http.StatusForbidden)
+		return nil, false
+	}
+
+	return auth.WithPrincipal(r.Context(), &auth.Principal{
+		UserID:   apiKey.UserID,
+		Username: apiKey.Username,
+		APIKeyID: apiKey.ID,
+	}), true
+}
+
+// IsRevoked comprueba si el token se revoco individualmente (logout) o junto
+// con todas las sesiones de su usuario (cambio de contrasena, borrado).
+func IsRevoked(repo Repositories.TokenRepository, claims *utils.Claims) (bool, error) {
+	revoked, err := repo.IsTokenRevoked(claims.Id)
+	if err != nil || revoked {
+		return revoked, err
+	}
+	return repo.IsSessionRevoked(claims.Username, claims.IssuedAtTime())
 }
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"SocialMedia/auth"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// forbiddenError es el cuerpo de la respuesta 403 de RequireRole.
+type forbiddenError struct {
+	Error struct {
+		Code          string   `json:"code"`
+		Message       string   `json:"message"`
+		RequiredRoles []string `json:"requiredRoles"`
+	} `json:"error"`
+}
+
+// RequireRole deja pasar solo a los usuarios con alguno de los roles. Va
+// dentro de AuthMiddleware, que es quien pone el usuario en el contexto.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.FromContext(r.Context())
+			if !ok {
+				http.Error(w, "no autenticado", http.StatusUnauthorized)
+				return
+			}
+			for _, role := range roles {
+				if principal.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			var body forbiddenError
+			body.Error.Code = "forbidden"
+			body.Error.Message = "no tienes permisos para esta accion"
+			body.Error.RequiredRoles = roles
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			if err := json.NewEncoder(w).Encode(body); err != nil {
+				log.Printf("Error writing response: %v", err)
+			}
+		})
+	}
+}
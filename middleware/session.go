@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"SocialMedia/utils"
+	"errors"
+	"net/http"
+)
+
+// Cookies de sesion de la web de temp/template.html. Son HttpOnly para que
+// el JavaScript de la pagina no pueda leer los tokens.
+const (
+	SessionCookieName = "session"
+	RefreshCookieName = "refresh_session"
+
+	// csrfHeader la tiene que enviar la web en las peticiones que cambian
+	// algo. Un formulario de otra web no puede anadir cabeceras, y un fetch
+	// con ella necesita un preflight CORS que este servidor no acepta.
+	csrfHeader = "X-Requested-With"
+)
+
+var errMissingCSRFHeader = errors.New("falta la cabecera " + csrfHeader)
+
+// SetSessionCookies guarda el par de tokens en las cookies de sesion.
+func SetSessionCookies(w http.ResponseWriter, r *http.Request, tokens *utils.TokenPair) {
+	http.SetCookie(w, sessionCookie(r, SessionCookieName, tokens.AccessToken, int(utils.AccessTokenDuration.Seconds())))
+	http.SetCookie(w, sessionCookie(r, RefreshCookieName, tokens.RefreshToken, int(utils.RefreshTokenDuration.Seconds())))
+}
+
+// ClearSessionCookies borra las cookies de sesion.
+func ClearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, sessionCookie(r, SessionCookieName, "", -1))
+	http.SetCookie(w, sessionCookie(r, RefreshCookieName, "", -1))
+}
+
+// RefreshCookie devuelve el refresh token de la cookie de sesion, o "" si no
+// la hay. Como las rutas autenticadas con la cookie, exige la cabecera
+// X-Requested-With.
+func RefreshCookie(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(RefreshCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", nil
+	}
+	if r.Header.Get(csrfHeader) == "" {
+		return "", errMissingCSRFHeader
+	}
+	return cookie.Value, nil
+}
+
+func sessionCookie(r *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// checkCSRF exige la cabecera X-Requested-With a las peticiones autenticadas
+// con la cookie que no son de solo lectura.
+func checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if r.Header.Get(csrfHeader) == "" {
+		http.Error(w, errMissingCSRFHeader.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
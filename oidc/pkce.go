@@ -0,0 +1,29 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// RandomString devuelve n bytes aleatorios en base64url, para state, nonce y
+// code verifier.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateCodeVerifier genera el code verifier de PKCE (RFC 7636), de 43
+// caracteres.
+func GenerateCodeVerifier() (string, error) {
+	return RandomString(32)
+}
+
+// CodeChallengeS256 devuelve el code challenge S256 de verifier.
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+type azureStorage struct {
+	containerURL azblob.ContainerURL
+	baseURL      string
+}
+
+// NewAzureStorage crea un BlobStorage sobre un contenedor de Azure Blob
+// Storage. Las credenciales se leen una sola vez al arrancar.
+func NewAzureStorage(accountName, accountKey, containerName string) (BlobStorage, error) {
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear credenciales: %w", err)
+	}
+
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+
+	baseURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName)
+	URL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error al parsear la URL: %w", err)
+	}
+
+	return &azureStorage{
+		containerURL: azblob.NewContainerURL(*URL, pipeline),
+		baseURL:      baseURL,
+	}, nil
+}
+
+func (s *azureStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	blobURL := s.containerURL.NewBlockBlobURL(key)
+	_, err := azblob.UploadBufferToBlockBlob(ctx, data, blobURL, azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			ContentType: contentType,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error al subir el archivo: %w", err)
+	}
+	return nil
+}
+
+func (s *azureStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	blobURL := s.containerURL.NewBlobURL(key)
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, azureError(err)
+	}
+
+	data := make([]byte, props.ContentLength())
+	err = azblob.DownloadBlobToBuffer(ctx, blobURL, 0, azblob.CountToEnd, data, azblob.DownloadFromBlobOptions{})
+	if err != nil {
+		return nil, azureError(err)
+	}
+	return data, nil
+}
+
+func (s *azureStorage) Delete(ctx context.Context, key string) error {
+	blobURL := s.containerURL.NewBlobURL(key)
+	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return azureError(err)
+}
+
+func (s *azureStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func azureError(err error) error {
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return ErrNotFound
+	}
+	return err
+}
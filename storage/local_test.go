@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalStorageDoesNotListDirectories(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir(), "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), "posts/p1/m1.png", []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	_, handler, _ := Handler(s)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/uploads/posts/p1/m1.png", http.StatusOK},
+		{"/uploads/", http.StatusNotFound},
+		{"/uploads/posts/", http.StatusNotFound},
+		{"/uploads/posts/p1/", http.StatusNotFound},
+		{"/uploads/posts/p1/missing.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: status %d, se esperaba %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
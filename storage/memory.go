@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type memoryBlob struct {
+	data        []byte
+	contentType string
+}
+
+type memoryStorage struct {
+	mu      sync.RWMutex
+	blobs   map[string]memoryBlob
+	baseURL string
+}
+
+// NewMemoryStorage crea un BlobStorage en memoria para tests y desarrollo.
+func NewMemoryStorage(baseURL string) BlobStorage {
+	return &memoryStorage{
+		blobs:   make(map[string]memoryBlob),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *memoryStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs[key] = memoryBlob{data: append([]byte(nil), data...), contentType: contentType}
+	return nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), blob.data...), nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blobs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *memoryStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *memoryStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, s.pathPrefix()+"/")
+
+	s.mu.RLock()
+	blob, ok := s.blobs[key]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", blob.contentType)
+	if _, err := w.Write(blob.data); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func (s *memoryStorage) pathPrefix() string {
+	return urlPathPrefix(s.baseURL)
+}
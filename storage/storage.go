@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob no encontrado")
+
+// BlobStorage abstrae el almacenamiento de archivos subidos por los usuarios.
+type BlobStorage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// New crea el BlobStorage indicado por la variable STORAGE_DRIVER
+// ("azure", "local" o "memory"). Por defecto se usa Azure.
+func New() (BlobStorage, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "azure":
+		return NewAzureStorage(
+			os.Getenv("AZURE_STORAGE_ACCOUNT_NAME"),
+			os.Getenv("AZURE_STORAGE_ACCOUNT_KEY"),
+			getEnv("AZURE_STORAGE_CONTAINER", "posts"),
+		)
+	case "local":
+		return NewLocalStorage(
+			getEnv("STORAGE_LOCAL_DIR", "uploads"),
+			getEnv("STORAGE_BASE_URL", "/uploads/"),
+		)
+	case "memory":
+		return NewMemoryStorage(getEnv("STORAGE_BASE_URL", "/uploads/")), nil
+	default:
+		return nil, fmt.Errorf("STORAGE_DRIVER desconocido: %q", driver)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// servingStorage lo implementan los drivers que sirven sus propios archivos
+// (local y memory), ya que no tienen un servidor externo como Azure.
+type servingStorage interface {
+	http.Handler
+	pathPrefix() string
+}
+
+// Handler devuelve el patron y el http.Handler con el que montar los archivos
+// del storage en el mux. ok es false si el driver no sirve archivos.
+func Handler(s BlobStorage) (pattern string, handler http.Handler, ok bool) {
+	serving, ok := s.(servingStorage)
+	if !ok {
+		return "", nil, false
+	}
+	return serving.pathPrefix() + "/", serving, true
+}
+
+// urlPathPrefix devuelve el path de baseURL sin la barra final, tanto si es
+// una URL absoluta como un path.
+func urlPathPrefix(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
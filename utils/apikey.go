@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// APIKeyPrefix distingue las API keys de los JWT en la cabecera
+	// Authorization.
+	APIKeyPrefix = "smk_"
+	// APIKeyDisplayLength es cuantos caracteres de la clave se guardan para
+	// mostrarla en el listado.
+	APIKeyDisplayLength = len(APIKeyPrefix) + 8
+)
+
+// GenerateAPIKey genera una API key nueva y su hash, que es lo unico que se
+// guarda.
+func GenerateAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey devuelve el SHA-256 de la clave. Al ser aleatoria y larga no
+// hace falta un hash lento como bcrypt, y asi se puede buscar por el hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
@@ -0,0 +1,468 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// maxImagePixels evita decodificar imagenes enormes (bombas de
+	// descompresion). 24 MP cubre las fotos de cualquier movil y son unos
+	// 100 MB en memoria una vez decodificada.
+	maxImagePixels = 24_000_000
+	// maxConcurrentDecodes limita cuantas imagenes se decodifican a la vez,
+	// para que varias subidas simultaneas no multipliquen la memoria.
+	maxConcurrentDecodes = 4
+	jpegQuality          = 90
+	// maxGIFFrames limita los frames de una animacion: cada uno se decodifica
+	// con su propia paleta aunque sea de un solo pixel.
+	maxGIFFrames = 1000
+)
+
+var (
+	ErrImageTooLarge = errors.New("la imagen tiene demasiados pixeles")
+	ErrInvalidImage  = errors.New("la imagen no se puede decodificar")
+)
+
+var decodeSlots = make(chan struct{}, maxConcurrentDecodes)
+
+// ImageRenditionSizes son las versiones reducidas que se generan de cada
+// imagen, con el tamano maximo de su lado mayor.
+var ImageRenditionSizes = []struct {
+	Name    string
+	MaxSize int
+}{
+	{Name: "thumbnail", MaxSize: 320},
+	{Name: "medium", MaxSize: 1080},
+}
+
+type ImageRendition struct {
+	Name        string
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+type ProcessedImage struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	Renditions  []ImageRendition
+}
+
+// ProcessImage prepara una imagen subida para guardarla: aplica la
+// orientacion EXIF a los pixeles, elimina todos los metadatos (incluida la
+// posicion GPS) volviendo a codificarla y genera las versiones de
+// ImageRenditionSizes. Los GIF se vuelven a codificar con todos sus frames
+// para no perder la animacion y sus versiones se generan del primer frame.
+// Los WebP no se pueden decodificar con la libreria estandar: se les quitan
+// los chunks de metadatos y se guardan sin versiones reducidas.
+func ProcessImage(data []byte, contentType string) (*ProcessedImage, error) {
+	if contentType == "image/webp" {
+		return processWebP(data)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	decodeSlots <- struct{}{}
+	defer func() { <-decodeSlots }()
+
+	processed := &ProcessedImage{ContentType: contentType}
+	var img image.Image
+	switch contentType {
+	case "image/jpeg", "image/png":
+		img, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		if contentType == "image/jpeg" {
+			img = applyOrientation(img, jpegOrientation(data))
+		}
+		processed.Data, err = encodeImage(img, contentType)
+	case "image/gif":
+		// gif.DecodeAll decodifica todos los frames antes de devolver nada,
+		// asi que los limites se comprueban antes leyendo sus cabeceras.
+		if err := checkGIFFrames(data); err != nil {
+			return nil, err
+		}
+		var animation *gif.GIF
+		animation, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(animation.Image) == 0 {
+			return nil, ErrInvalidImage
+		}
+		img = animation.Image[0]
+		processed.Data, err = encodeGIF(animation)
+	default:
+		return nil, fmt.Errorf("tipo de imagen no soportado: %s", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	processed.Width, processed.Height = bounds.Dx(), bounds.Dy()
+
+	renditionType := contentType
+	if contentType != "image/jpeg" {
+		renditionType = "image/png"
+	}
+	for _, size := range ImageRenditionSizes {
+		resized := resizeToFit(img, size.MaxSize)
+		encoded, err := encodeImage(resized, renditionType)
+		if err != nil {
+			return nil, err
+		}
+		processed.Renditions = append(processed.Renditions, ImageRendition{
+			Name:        size.Name,
+			Data:        encoded,
+			ContentType: renditionType,
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+		})
+	}
+
+	return processed, nil
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeGIF vuelve a escribir una animacion con solo los frames, la paleta
+// y el numero de repeticiones; los comentarios y las extensiones de
+// aplicacion (XMP incluido) se pierden por el camino.
+func encodeGIF(animation *gif.GIF) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkGIFFrames recorre los bloques de un GIF sin decodificar los pixeles y
+// devuelve ErrImageTooLarge si tiene mas de maxGIFFrames frames o si entre
+// todos suman mas de maxImagePixels pixeles.
+func checkGIFFrames(data []byte) error {
+	// Cabecera (6 bytes) y descriptor de pantalla logica (7 bytes), seguidos
+	// de la paleta global si el bit alto de los flags esta activo.
+	if len(data) < 13 {
+		return ErrInvalidImage
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1)
+	}
+
+	var frames, pixels int
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: etiqueta y sub-bloques.
+			if i+2 > len(data) {
+				return ErrInvalidImage
+			}
+			i += 2
+		case 0x2c: // Descriptor de imagen: un frame.
+			if i+10 > len(data) {
+				return ErrInvalidImage
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5 : i+7]))
+			height := int(binary.LittleEndian.Uint16(data[i+7 : i+9]))
+			frames++
+			pixels += width * height
+			if frames > maxGIFFrames || pixels > maxImagePixels {
+				return ErrImageTooLarge
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 0x07) + 1)
+			}
+			// Tamano minimo del codigo LZW antes de los sub-bloques.
+			i++
+		case 0x3b: // Fin del fichero.
+			return nil
+		default:
+			return ErrInvalidImage
+		}
+
+		// Sub-bloques de datos hasta el de tamano 0.
+		for {
+			if i >= len(data) {
+				return ErrInvalidImage
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	// Sin bloque final: gif.DecodeAll decidira si el fichero es valido.
+	return nil
+}
+
+// processWebP quita los chunks EXIF y XMP de un WebP (y sus flags en la
+// cabecera VP8X) sin tocar los datos de la imagen, y lee sus dimensiones
+// de la cabecera.
+func processWebP(data []byte) (*ProcessedImage, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrInvalidImage
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	var width, height int
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		if size > len(data)-i-8 {
+			return nil, ErrInvalidImage
+		}
+		payload := data[i+8 : i+8+size]
+		// Los chunks de tamano impar llevan un byte de relleno.
+		next := min(i+8+size+size%2, len(data))
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+			i = next
+			continue
+		case "VP8X":
+			if size < 10 {
+				return nil, ErrInvalidImage
+			}
+			width = 1 + int(uint32(payload[4])|uint32(payload[5])<<8|uint32(payload[6])<<16)
+			height = 1 + int(uint32(payload[7])|uint32(payload[8])<<8|uint32(payload[9])<<16)
+			chunk := append([]byte(nil), data[i:next]...)
+			chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			out = append(out, chunk...)
+			i = next
+			continue
+		case "VP8 ":
+			if width == 0 {
+				if size < 10 || !bytes.Equal(payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+					return nil, ErrInvalidImage
+				}
+				width = int(binary.LittleEndian.Uint16(payload[6:8]) & 0x3fff)
+				height = int(binary.LittleEndian.Uint16(payload[8:10]) & 0x3fff)
+			}
+		case "VP8L":
+			if width == 0 {
+				if size < 5 || payload[0] != 0x2f {
+					return nil, ErrInvalidImage
+				}
+				bits := binary.LittleEndian.Uint32(payload[1:5])
+				width = int(bits&0x3fff) + 1
+				height = int(bits>>14&0x3fff) + 1
+			}
+		}
+		out = append(out, data[i:next]...)
+		i = next
+	}
+
+	if width == 0 || height == 0 {
+		return nil, ErrInvalidImage
+	}
+	if width*height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+
+	return &ProcessedImage{Data: out, ContentType: "image/webp", Width: width, Height: height}, nil
+}
+
+// Flags de la cabecera VP8X que indican que hay chunks de metadatos.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// resizeToFit reduce la imagen para que su lado mayor no supere maxSize,
+// promediando los pixeles de origen que caen en cada pixel de destino. Las
+// imagenes mas pequenas no se amplian.
+func resizeToFit(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= maxSize && srcH <= maxSize {
+		return img
+	}
+
+	dstW, dstH := maxSize, srcH*maxSize/srcW
+	if srcH > srcW {
+		dstW, dstH = srcW*maxSize/srcH, maxSize
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				offset := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					pixel := src.Pix[offset : offset+4 : offset+4]
+					alpha := uint64(pixel[3])
+					r += uint64(pixel[0]) * alpha
+					g += uint64(pixel[1]) * alpha
+					b += uint64(pixel[2]) * alpha
+					a += alpha
+					n++
+					offset += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			if a > 0 {
+				dst.Pix[i] = uint8(r / a)
+				dst.Pix[i+1] = uint8(g / a)
+				dst.Pix[i+2] = uint8(b / a)
+			}
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	if nrgba, ok := img.(*image.NRGBA); ok && bounds.Min == (image.Point{}) {
+		return nrgba
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// applyOrientation gira o voltea la imagen segun el valor de la etiqueta
+// EXIF Orientation (1-8) para que se vea derecha sin los metadatos.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src := toNRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // espejo horizontal
+				sx, sy = w-1-x, y
+			case 3: // 180 grados
+				sx, sy = w-1-x, h-1-y
+			case 4: // espejo vertical
+				sx, sy = x, h-1-y
+			case 5: // traspuesta
+				sx, sy = y, x
+			case 6: // 90 grados horario
+				sx, sy = y, h-1-x
+			case 7: // traspuesta inversa
+				sx, sy = w-1-y, h-1-x
+			case 8: // 90 grados antihorario
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation devuelve el valor de la etiqueta EXIF Orientation de un
+// JPEG, o 1 si no la tiene.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// SOS: empiezan los datos de la imagen, ya no hay mas cabeceras.
+		if marker == 0xDA {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation busca la etiqueta Orientation (0x0112) en el IFD0 de un
+// bloque TIFF de EXIF.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	file := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(file[4:8], uint32(len(body)))
+	return append(file, body...)
+}
+
+func TestProcessWebPStripsMetadata(t *testing.T) {
+	// Canvas de 640x480 con flags de EXIF y XMP.
+	vp8x := []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 0x7f, 0x02, 0, 0xdf, 0x01, 0}
+	vp8l := []byte{0x2f, 0, 0, 0, 0, 0}
+	input := webpFile(
+		webpChunk("VP8X", vp8x),
+		webpChunk("VP8L", vp8l),
+		webpChunk("EXIF", []byte("Exif\x00\x00GPS")),
+		webpChunk("XMP ", []byte("<x:xmpmeta/>")),
+	)
+
+	processed, err := ProcessImage(input, "image/webp")
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	if processed.Width != 640 || processed.Height != 480 {
+		t.Errorf("dimensiones = %dx%d, se esperaba 640x480", processed.Width, processed.Height)
+	}
+	want := webpFile(webpChunk("VP8X", append([]byte{0}, vp8x[1:]...)), webpChunk("VP8L", vp8l))
+	if !bytes.Equal(processed.Data, want) {
+		t.Errorf("Data = %q, se esperaba %q", processed.Data, want)
+	}
+}
+
+func TestProcessWebPRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{name: "no es RIFF", input: []byte("GIF89a......"), want: ErrInvalidImage},
+		{name: "chunk truncado", input: webpFile(webpChunk("VP8L", []byte{0x2f, 0, 0, 0, 0}))[:20], want: ErrInvalidImage},
+		{name: "sin datos de imagen", input: webpFile(webpChunk("EXIF", []byte("Exif"))), want: ErrInvalidImage},
+		{name: "demasiados pixeles", input: webpFile(webpChunk("VP8L", []byte{0x2f, 0xff, 0xff, 0xff, 0x0f})), want: ErrImageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ProcessImage(tt.input, "image/webp"); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, se esperaba %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessImageReencodesGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		frame.SetColorIndex(i, i, 1)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	// Extension de comentario justo despues de la cabecera (sin paleta
+	// global, cada frame lleva la suya).
+	input := append([]byte(nil), buf.Bytes()[:13]...)
+	input = append(input, 0x21, 0xfe, 4, 'G', 'P', 'S', '!', 0)
+	input = append(input, buf.Bytes()[13:]...)
+
+	processed, err := ProcessImage(input, "image/gif")
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	if bytes.Contains(processed.Data, []byte("GPS!")) {
+		t.Error("el GIF procesado conserva el comentario")
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(processed.Data))
+	if err != nil {
+		t.Fatalf("el GIF procesado no se puede decodificar: %v", err)
+	}
+	if len(decoded.Image) != len(animation.Image) {
+		t.Errorf("frames = %d, se esperaba %d", len(decoded.Image), len(animation.Image))
+	}
+	if len(processed.Renditions) != len(ImageRenditionSizes) {
+		t.Errorf("renditions = %d, se esperaba %d", len(processed.Renditions), len(ImageRenditionSizes))
+	}
+}
+
+// gifFile escribe un GIF con frames frames de width x height. Los datos LZW
+// son los de un frame vacio: solo importan las cabeceras, que es lo que se
+// comprueba antes de decodificar.
+func gifFile(width, height, frames int) []byte {
+	file := []byte("GIF89a")
+	file = binary.LittleEndian.AppendUint16(file, uint16(width))
+	file = binary.LittleEndian.AppendUint16(file, uint16(height))
+	file = append(file, 0, 0, 0)
+	for i := 0; i < frames; i++ {
+		file = append(file, 0x21, 0xf9, 4, 0, 10, 0, 0, 0)
+		file = append(file, 0x2c, 0, 0, 0, 0)
+		file = binary.LittleEndian.AppendUint16(file, uint16(width))
+		file = binary.LittleEndian.AppendUint16(file, uint16(height))
+		// Paleta local de 2 colores, codigo LZW minimo 2 y un sub-bloque.
+		file = append(file, 0x80, 0, 0, 0, 0xff, 0xff, 0xff, 2, 2, 0x4c, 0x01, 0)
+	}
+	return append(file, 0x3b)
+}
+
+func TestProcessImageRejectsLargeGIFsBeforeDecoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{name: "demasiados frames", input: gifFile(1, 1, maxGIFFrames+1), want: ErrImageTooLarge},
+		{name: "demasiados pixeles entre todos los frames", input: gifFile(4000, 4000, 2), want: ErrImageTooLarge},
+		{name: "truncado", input: gifFile(1, 1, 1)[:20], want: ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ProcessImage(tt.input, "image/gif"); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, se esperaba %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := checkGIFFrames(gifFile(8, 8, 3)); err != nil {
+		t.Errorf("checkGIFFrames de un GIF pequeno = %v", err)
+	}
+}
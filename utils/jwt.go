@@ -1,28 +1,152 @@
 package utils
 
 import (
+	data "SocialMedia/Data"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+	// EmailChangeTokenType confirma el cambio de email; Value es el nuevo email.
+	EmailChangeTokenType = "email_change"
+	// EmailVerificationTokenType verifica el email del registro; Value es el
+	// email verificado.
+	EmailVerificationTokenType = "email_verification"
+	// PasswordResetTokenType permite cambiar la contrasena olvidada; Value
+	// identifica la contrasena actual para que el token sea de un solo uso.
+	PasswordResetTokenType = "password_reset"
+	// MFAPendingTokenType lo recibe quien ha acertado la contrasena de una
+	// cuenta con verificacion en dos pasos; solo sirve para /login/2fa.
+	MFAPendingTokenType = "mfa_pending"
+
+	AccessTokenDuration  = 15 * time.Minute
+	RefreshTokenDuration = 30 * 24 * time.Hour
+	MFAPendingDuration   = 5 * time.Minute
+)
+
+// tokenAudiences es el aud de cada tipo de token: la parte de la API que lo
+// acepta.
+var tokenAudiences = map[string]string{
+	AccessTokenType:            "api",
+	RefreshTokenType:           "/token/refresh",
+	EmailChangeTokenType:       "/email/confirm",
+	EmailVerificationTokenType: "/verify-email",
+	PasswordResetTokenType:     "/password/reset",
+	MFAPendingTokenType:        "/login/2fa",
+}
+
+// tokenIssuer es el iss de los tokens (JWT_ISSUER, por defecto SocialMedia).
+// Si otro servicio comparte las claves, cada uno debe tener el suyo.
+func tokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "SocialMedia"
+}
+
+// Claims son los datos de todos los tokens. Todos se firman con las mismas
+// claves, asi que quien valide un token tiene que comprobar su TokenType
+// (y su aud): si no, un refresh token, un token de mfa_pending o el enlace
+// de un email servirian como access token. ValidateToken,
+// ValidateRefreshToken y ValidateActionToken ya lo hacen; no se debe
+// parsear un token de otra forma.
 type Claims struct {
-	Username string `json:"username"`
+	UserID    string `json:"uid,omitempty"`
+	Username  string `json:"username"`
+	TokenType string `json:"type"`
+	Value     string `json:"value,omitempty"`
+	// Roles son los roles del usuario al emitir el access token.
+	Roles []string `json:"roles,omitempty"`
+	// IssuedAtMillis es IssuedAt en milisegundos, para compararlo con el
+	// corte de RevokeUserTokens sin perder los tokens emitidos justo despues
+	// en el mismo segundo.
+	IssuedAtMillis int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(username string) (string, error) {
-	jwtSecret := []byte(os.Getenv("JWT"))
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+// ExpiresAtTime devuelve la expiracion del token como time.Time.
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// IssuedAtTime devuelve cuando se emitio el token como time.Time, con
+// milisegundos si el token los lleva.
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAtMillis != 0 {
+		return time.UnixMilli(c.IssuedAtMillis)
 	}
+	return time.Unix(c.IssuedAt, 0)
+}
 
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// GenerateToken genera un access token de corta duracion con el id y los
+// roles del usuario.
+func GenerateToken(user *data.User) (string, error) {
+	return signToken(&Claims{UserID: user.ID, Username: user.Username, TokenType: AccessTokenType, Roles: user.Roles}, AccessTokenDuration)
+}
+
+// GenerateRefreshToken genera un refresh token de larga duracion que solo
+// sirve para obtener nuevos access tokens en /token/refresh.
+func GenerateRefreshToken(username string) (string, error) {
+	return generateToken(username, RefreshTokenType, RefreshTokenDuration)
+}
+
+func GenerateTokenPair(user *data.User) (*TokenPair, error) {
+	accessToken, err := GenerateToken(user)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := GenerateRefreshToken(user.Username)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenDuration.Seconds()),
+	}, nil
+}
+
+// GenerateActionToken genera un token para una accion concreta (tokenType)
+// que se envia al usuario, por ejemplo por email. value es el dato que
+// confirma, como el nuevo email.
+func GenerateActionToken(username, tokenType, value string, duration time.Duration) (string, error) {
+	return signToken(&Claims{Username: username, TokenType: tokenType, Value: value}, duration)
+}
+
+func generateToken(username, tokenType string, duration time.Duration) (string, error) {
+	return signToken(&Claims{Username: username, TokenType: tokenType}, duration)
+}
+
+func signToken(claims *Claims, duration time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAtMillis = now.UnixMilli()
+	claims.StandardClaims = jwt.StandardClaims{
+		Id:        uuid.New().String(),
+		Issuer:    tokenIssuer(),
+		Audience:  tokenAudiences[claims.TokenType],
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
+	}
+
+	if keys != nil {
+		token := jwt.NewWithClaims(keys.active.method, claims)
+		token.Header["kid"] = keys.active.kid
+		return token.SignedString(keys.active.private)
+	}
+
+	jwtSecret := []byte(os.Getenv("JWT"))
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
@@ -31,15 +155,28 @@ func GenerateToken(username string) (string, error) {
 	return tokenString, nil
 }
 
+// ValidateToken valida un access token. Los tokens de cualquier otro tipo
+// (refresh, mfa_pending, los de los emails...) son rechazados.
 func ValidateToken(tokenString string) (*Claims, error) {
-	jwtSecret := []byte(os.Getenv("JWT"))
+	return validateToken(tokenString, AccessTokenType)
+}
+
+func ValidateRefreshToken(tokenString string) (*Claims, error) {
+	return validateToken(tokenString, RefreshTokenType)
+}
+
+// ValidateActionToken valida un token generado con GenerateActionToken para
+// tokenType.
+func ValidateActionToken(tokenString, tokenType string) (*Claims, error) {
+	return validateToken(tokenString, tokenType)
+}
+
+// validateToken comprueba la firma, la caducidad, el tipo, el iss y el aud
+// que corresponde a tokenType.
+func validateToken(tokenString, tokenType string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("metodo de firma no valido: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg(), jwt.SigningMethodHS256.Alg()}}
+	token, err := parser.ParseWithClaims(tokenString, claims, verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -48,5 +185,12 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("token invalido")
 	}
 
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("tipo de token invalido")
+	}
+	if !claims.VerifyIssuer(tokenIssuer(), true) || !claims.VerifyAudience(tokenAudiences[tokenType], true) {
+		return nil, fmt.Errorf("emisor o audiencia del token invalidos")
+	}
+
 	return claims, nil
 }
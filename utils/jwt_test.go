@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestIssuedAtTimeKeepsMilliseconds(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+
+	before := time.Now().Truncate(time.Millisecond)
+	token, err := GenerateRefreshToken("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuedAt := claims.IssuedAtTime(); issuedAt.Before(before) {
+		t.Errorf("IssuedAtTime = %v, anterior a %v", issuedAt, before)
+	}
+
+	legacy := &Claims{}
+	legacy.IssuedAt = 1_700_000_000
+	if got := legacy.IssuedAtTime(); !got.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Errorf("IssuedAtTime sin iat_ms = %v", got)
+	}
+}
+
+func TestValidateRejectsOtherTokenTypes(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+
+	tokenTypes := []string{
+		AccessTokenType,
+		RefreshTokenType,
+		EmailChangeTokenType,
+		EmailVerificationTokenType,
+		PasswordResetTokenType,
+		MFAPendingTokenType,
+	}
+	for _, signedType := range tokenTypes {
+		token, err := signToken(&Claims{Username: "alice", TokenType: signedType}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expectedType := range tokenTypes {
+			_, err := validateToken(token, expectedType)
+			if valid := err == nil; valid != (signedType == expectedType) {
+				t.Errorf("token %s validado como %s: err = %v", signedType, expectedType, err)
+			}
+		}
+	}
+}
+
+func TestValidateChecksIssuerAndAudience(t *testing.T) {
+	t.Setenv("JWT", "test-secret")
+
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+	}{
+		{name: "otro emisor", issuer: "otro-servicio", audience: tokenAudiences[AccessTokenType]},
+		{name: "sin emisor", audience: tokenAudiences[AccessTokenType]},
+		{name: "audiencia de otro tipo", issuer: tokenIssuer(), audience: tokenAudiences[RefreshTokenType]},
+		{name: "sin audiencia", issuer: tokenIssuer()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{Username: "alice", TokenType: AccessTokenType}
+			claims.Issuer = tt.issuer
+			claims.Audience = tt.audience
+			claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ValidateToken(token); err == nil {
+				t.Fatal("se acepto el token")
+			}
+		})
+	}
+
+	t.Run("JWT_ISSUER distinto al validar", func(t *testing.T) {
+		token, err := GenerateActionToken("alice", PasswordResetTokenType, "", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("JWT_ISSUER", "otro-servicio")
+		if _, err := ValidateActionToken(token, PasswordResetTokenType); err == nil {
+			t.Fatal("se acepto un token de otro emisor")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const minRSAKeyBits = 2048
+
+// signingKey es una clave del directorio JWT_KEYS_DIR. Las claves que solo
+// tienen la parte publica sirven para verificar tokens, no para firmar.
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+type keyRing struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// keys son las claves cargadas con LoadSigningKeys. Sin ellas se firma con
+// HS256 y el secreto de la variable JWT.
+var keys *keyRing
+
+// LoadSigningKeys carga las claves para firmar los tokens de JWT_KEYS_DIR,
+// un directorio con una clave RSA o Ed25519 en PEM por fichero <kid>.pem:
+//
+//	openssl genpkey -algorithm ed25519 -out keys/2024-06.pem
+//	openssl genpkey -algorithm rsa -pkeyopt rsa_keygen_bits:2048 -out keys/2024-06.pem
+//
+// Se firma con la clave JWT_ACTIVE_KID (no hace falta si solo hay una clave
+// privada) y se verifica con todas, asi que para rotar basta con anadir la
+// nueva clave, activarla y borrar la anterior cuando hayan caducado sus
+// tokens. Mientras JWT siga definida se aceptan tambien los tokens HS256, para
+// no cerrar las sesiones al pasar a claves asimetricas.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		keys = nil
+		return nil
+	}
+
+	ring, err := loadKeyRing(dir, os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		return err
+	}
+	keys = ring
+	return nil
+}
+
+func loadKeyRing(dir, activeKid string) (*keyRing, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &keyRing{keys: make(map[string]*signingKey)}
+	var privateKeys []*signingKey
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+		key, err := readSigningKey(filepath.Join(dir, entry.Name()), kid)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[kid] = key
+		if key.private != nil {
+			privateKeys = append(privateKeys, key)
+		}
+	}
+
+	if activeKid == "" {
+		if len(privateKeys) != 1 {
+			return nil, fmt.Errorf("hay %d claves privadas en %s, elige la activa con JWT_ACTIVE_KID", len(privateKeys), dir)
+		}
+		ring.active = privateKeys[0]
+		return ring, nil
+	}
+
+	active, ok := ring.keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("no existe la clave %q en %s", activeKid, dir)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("la clave %q no tiene la parte privada", activeKid)
+	}
+	ring.active = active
+	return ring, nil
+}
+
+func readSigningKey(path, kid string) (*signingKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s no es un fichero PEM", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("tipo de bloque PEM no soportado: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("clave %q invalida: %w", kid, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("la clave %q tiene menos de %d bits", kid, minRSAKeyBits)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("la clave %q tiene menos de %d bits", kid, minRSAKeyBits)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: SigningMethodEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("la clave %q no es RSA ni Ed25519", kid)
+	}
+}
+
+// verificationKey devuelve la clave con la que se verifica token segun su
+// alg y su kid.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		secret := os.Getenv("JWT")
+		if secret == "" {
+			return nil, fmt.Errorf("metodo de firma no valido: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}
+
+	if keys == nil {
+		return nil, fmt.Errorf("metodo de firma no valido: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("clave desconocida: %q", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("metodo de firma no valido para la clave %q: %v", kid, token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JSONWebKey es una clave publica en formato JWK (RFC 7517).
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet es la respuesta de /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicKeys devuelve las claves publicas con las que se pueden verificar los
+// tokens, para que otros servicios los validen sin compartir un secreto. Los
+// tokens HS256 no se pueden verificar fuera.
+func PublicKeys() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if keys == nil {
+		return set
+	}
+
+	for _, key := range keys.keys {
+		jwk := JSONWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
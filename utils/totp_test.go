@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret es el secreto SHA1 de los vectores del apendice B del RFC
+// 6238 ("12345678901234567890") en base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Los vectores del RFC son de 8 digitos; con 6 digitos el codigo son sus
+// ultimos 6.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestValidateTOTPRFC6238Vectors(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		counter, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("ValidateTOTP(%s) en %d no acepta el codigo", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / 30; counter != want {
+			t.Errorf("contador en %d = %d, se esperaba %d", tt.unix, counter, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	const unix, code = 1111111111, "050471"
+	period := int64(totpPeriod.Seconds())
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"mismo periodo", 0, true},
+		{"un periodo despues", period, true},
+		{"un periodo antes", -period, true},
+		{"dos periodos despues", 2 * period, false},
+		{"dos periodos antes", -2 * period, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(unix+tt.offset, 0))
+			if ok != tt.want {
+				t.Fatalf("ValidateTOTP = %v, se esperaba %v", ok, tt.want)
+			}
+			// El contador es el del codigo, no el del instante, para que
+			// UseTOTPCounter rechace reutilizarlo dentro del desfase.
+			if ok && counter != unix/period {
+				t.Errorf("contador = %d, se esperaba %d", counter, unix/period)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, tt := range []struct{ secret, code string }{
+		{rfc6238Secret, "28708"},
+		{rfc6238Secret, "2870820"},
+		{rfc6238Secret, "abcdef"},
+		{"no es base32!", "287082"},
+	} {
+		if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok {
+			t.Errorf("ValidateTOTP(%q, %q) acepta el codigo", tt.secret, tt.code)
+		}
+	}
+	// El secreto se acepta en minusculas y con espacios, como lo copian
+	// algunas apps.
+	if _, ok := ValidateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "287082", now); !ok {
+		t.Errorf("ValidateTOTP no acepta el secreto con espacios y minusculas")
+	}
+}